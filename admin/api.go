@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+// openAPIDocument describes the /api/v1 endpoints.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// The JSON types of the API. They are kept apart from the repository types
+// so that changes to those don't change the API.
+type (
+	apiList struct {
+		Data interface{} `json:"data"`
+		// NextCursor is the cursor of the next page, empty on the last one.
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+
+	apiProcessedURL struct {
+		ID           int64  `json:"id"`
+		URL          string `json:"url"`
+		Timestamp    string `json:"timestamp"`
+		FileSize     int64  `json:"file_size"`
+		PreviewImage string `json:"preview_image,omitempty"`
+		Tags         string `json:"tags"`
+		Description  string `json:"description"`
+		Platform     string `json:"platform"`
+		Uploader     string `json:"uploader"`
+		ContentHash  string `json:"content_hash,omitempty"`
+		SharedWith   int    `json:"shared_with"`
+	}
+
+	apiDownload struct {
+		ID           int64  `json:"id"`
+		UserID       int64  `json:"user_id"`
+		URL          string `json:"url"`
+		Timestamp    string `json:"timestamp"`
+		FileSize     int64  `json:"file_size"`
+		PreviewImage string `json:"preview_image,omitempty"`
+		Tags         string `json:"tags"`
+		Description  string `json:"description"`
+		Platform     string `json:"platform"`
+		Uploader     string `json:"uploader"`
+	}
+
+	apiUser struct {
+		UserID       int64  `json:"user_id"`
+		Username     string `json:"username"`
+		FirstName    string `json:"first_name"`
+		LastName     string `json:"last_name"`
+		Downloads    int    `json:"downloads"`
+		Bytes        int64  `json:"bytes"`
+		LastDownload string `json:"last_download,omitempty"`
+	}
+
+	apiTagCount struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+
+	apiStatistics struct {
+		TotalFileSize  int64         `json:"total_file_size"`
+		TotalDownloads int           `json:"total_downloads"`
+		TopUsers       []apiUser     `json:"top_users"`
+		TopTags        []apiTagCount `json:"top_tags"`
+	}
+)
+
+func newAPIUser(u repository.UserStats) apiUser {
+	return apiUser{UserID: u.UserID, Username: u.Username, FirstName: u.FirstName, LastName: u.LastName,
+		Downloads: u.Downloads, Bytes: u.Bytes, LastDownload: u.LastDownload}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("Error encoding API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// apiKeyFromRequest returns the key in the Authorization header, sent as
+// "Bearer <key>".
+func apiKeyFromRequest(r *http.Request) string {
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+// RequireAPI lets requests with a valid API key through to h. Without a
+// key, the session cookie of a logged in admin is accepted too, so the
+// pages can use the API. The API only reads, so only GET is allowed.
+func (a *Auth) RequireAPI(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if key := apiKeyFromRequest(r); key != "" {
+			apiKey, err := repo.APIKeyByHash(hashToken(key))
+			if err == nil {
+				if err := repo.TouchAPIKey(apiKey.ID); err != nil {
+					logger.Printf("Error recording use of API key %s: %v", apiKey.Name, err)
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+			if err != repository.ErrNotFound {
+				logger.Printf("Error querying API key: %v", err)
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			logger.Printf("Rejected API key from %s", r.RemoteAddr)
+		} else if _, err := a.session(r); err == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid API key")
+	})
+}
+
+func encodeCursor(after int64) string {
+	if after == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(after, 10)))
+}
+
+// parseCursor reads the cursor, limit and order (asc or desc, the default)
+// parameters of the list endpoints.
+func parseCursor(r *http.Request) (repository.Cursor, error) {
+	q := r.URL.Query()
+	cursor := repository.Cursor{Limit: repository.DefaultPageSize}
+	if v := q.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			cursor.After, err = strconv.ParseInt(string(b), 10, 64)
+		}
+		if err != nil || cursor.After <= 0 {
+			return cursor, fmt.Errorf("invalid cursor %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			return cursor, fmt.Errorf("invalid limit %q", v)
+		}
+		cursor.Limit = limit
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		cursor.Asc = true
+	default:
+		return cursor, fmt.Errorf("invalid order %q", q.Get("order"))
+	}
+	return cursor, nil
+}
+
+// apiListQuery reads the filters, with sizes in bytes, and cursor of a list
+// endpoint, answering 400 when they are invalid.
+func apiListQuery(w http.ResponseWriter, r *http.Request) (repository.ListFilter, repository.Cursor, bool) {
+	filter, err := parseListFilter(r.URL.Query(), 1)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return filter, repository.Cursor{}, false
+	}
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return filter, cursor, false
+	}
+	return filter, cursor, true
+}
+
+func apiProcessedURLsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, cursor, ok := apiListQuery(w, r)
+	if !ok {
+		return
+	}
+	urls, next, err := repo.ProcessedURLsAfter(repository.ProcessedURLFilter{ListFilter: filter, ContentHash: r.URL.Query().Get("content_hash")}, cursor)
+	if err != nil {
+		logger.Printf("Error querying processed URLs: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := []apiProcessedURL{}
+	for _, u := range urls {
+		data = append(data, apiProcessedURL{ID: u.ID, URL: u.URL, Timestamp: u.Timestamp, FileSize: u.FileSize, PreviewImage: u.PreviewImage,
+			Tags: u.Tags, Description: u.Description, Platform: u.Platform, Uploader: u.Uploader, ContentHash: u.ContentHash, SharedWith: u.SharedWith})
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: data, NextCursor: encodeCursor(next)})
+}
+
+func apiDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, cursor, ok := apiListQuery(w, r)
+	if !ok {
+		return
+	}
+	downloads, next, err := repo.DownloadsAfter(filter, cursor)
+	if err != nil {
+		logger.Printf("Error querying user downloads: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := []apiDownload{}
+	for _, d := range downloads {
+		data = append(data, apiDownload{ID: d.ID, UserID: d.UserID, URL: d.URL, Timestamp: d.Timestamp, FileSize: d.FileSize, PreviewImage: d.PreviewImage,
+			Tags: d.Tags, Description: d.Description, Platform: d.Platform, Uploader: d.Uploader})
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: data, NextCursor: encodeCursor(next)})
+}
+
+// apiUsersHandler lists the users by Telegram ID, optionally only the one
+// with the username parameter.
+func apiUsersHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	users, next, err := repo.UsersAfter(strings.TrimSpace(r.URL.Query().Get("username")), cursor)
+	if err != nil {
+		logger.Printf("Error querying users: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := []apiUser{}
+	for _, u := range users {
+		data = append(data, newAPIUser(u))
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: data, NextCursor: encodeCursor(next)})
+}
+
+func apiUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid user ID %q", r.PathValue("id")))
+		return
+	}
+	user, err := repo.User(id)
+	if err == repository.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		logger.Printf("Error querying user %d: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIUser(user))
+}
+
+// apiStatisticsHandler returns the all time totals and top lists. The
+// statistics endpoints of a date range are in stats.go.
+func apiStatisticsHandler(w http.ResponseWriter, r *http.Request) {
+	var stats apiStatistics
+	var err error
+	stats.TotalFileSize, stats.TotalDownloads, err = repo.Totals()
+	if err != nil {
+		logger.Printf("Error querying totals: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	topUsers, err := repo.TopUsers(repository.StatsFilter{}, 10)
+	if err != nil {
+		logger.Printf("Error querying user stats: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	topTags, err := repo.TopTags(repository.StatsFilter{}, 20)
+	if err != nil {
+		logger.Printf("Error querying top tags: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats.TopUsers, stats.TopTags = []apiUser{}, []apiTagCount{}
+	for _, u := range topUsers {
+		stats.TopUsers = append(stats.TopUsers, newAPIUser(u))
+	}
+	for _, t := range topTags {
+		stats.TopTags = append(stats.TopTags, apiTagCount{Tag: t.Tag, Count: t.Count})
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// openAPIHandler serves the OpenAPI document. It needs no key, so tools
+// can fetch it before one is set up.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// registerAPI adds the /api/v1 endpoints to the default mux.
+func registerAPI(auth *Auth) {
+	http.HandleFunc("/api/v1/openapi.json", openAPIHandler)
+	http.Handle("/api/v1/processed_urls", auth.RequireAPI(http.HandlerFunc(apiProcessedURLsHandler)))
+	http.Handle("/api/v1/downloads", auth.RequireAPI(http.HandlerFunc(apiDownloadsHandler)))
+	http.Handle("/api/v1/users", auth.RequireAPI(http.HandlerFunc(apiUsersHandler)))
+	http.Handle("/api/v1/users/{id}", auth.RequireAPI(http.HandlerFunc(apiUserHandler)))
+	http.Handle("/api/v1/statistics", auth.RequireAPI(http.HandlerFunc(apiStatisticsHandler)))
+	http.Handle("/api/v1/statistics/summary", auth.RequireAPI(http.HandlerFunc(apiStatsSummaryHandler)))
+	http.Handle("/api/v1/statistics/series", auth.RequireAPI(http.HandlerFunc(apiStatsSeriesHandler)))
+	http.Handle("/api/v1/statistics/top", auth.RequireAPI(http.HandlerFunc(apiStatsTopHandler)))
+	http.Handle("/api/v1/statistics/failures", auth.RequireAPI(http.HandlerFunc(apiStatsFailuresHandler)))
+	http.Handle("/api/v1/", auth.RequireAPI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no such endpoint")
+	})))
+}
+
+// apiKeyCommand implements `admin create-api-key <name>`, which prints the
+// new key, `admin list-api-keys` and `admin revoke-api-key <name>`.
+func apiKeyCommand(command string, args []string) error {
+	switch {
+	case command == "create-api-key" && len(args) == 1 && args[0] != "":
+		key := randomToken()
+		if err := repo.CreateAPIKey(args[0], hashToken(key)); err != nil {
+			return fmt.Errorf("failed to create API key %s: %w", args[0], err)
+		}
+		log.Printf("Created API key %s; it is only shown this once", args[0])
+		fmt.Println(key)
+		return nil
+	case command == "list-api-keys" && len(args) == 0:
+		keys, err := repo.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		for _, k := range keys {
+			lastUsed := "never used"
+			if !k.LastUsedAt.IsZero() {
+				lastUsed = "last used " + k.LastUsedAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(out, "%s\tcreated %s\t%s\n", k.Name, k.CreatedAt.Format(time.DateTime), lastUsed)
+		}
+		return nil
+	case command == "revoke-api-key" && len(args) == 1:
+		if err := repo.DeleteAPIKey(args[0]); err != nil {
+			if err == repository.ErrNotFound {
+				return fmt.Errorf("no API key named %s", args[0])
+			}
+			return err
+		}
+		log.Printf("Revoked API key %s", args[0])
+		return nil
+	}
+	return errors.New("usage: admin create-api-key <name> | list-api-keys | revoke-api-key <name>")
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"instaVideoDownloaderBot/repository"
+)
+
+// Roles of admin accounts. Viewers can see every page; operators can also
+// submit the forms that change something, like broadcasts and janitor runs.
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+)
+
+const (
+	sessionCookie   = "admin_session"
+	loginCSRFCookie = "admin_login_csrf"
+	csrfField       = "csrf_token"
+	// telegramAuthMaxAge is how old the data of a Telegram login may be.
+	telegramAuthMaxAge = 24 * time.Hour
+)
+
+type contextKey int
+
+const sessionKey contextKey = 0
+
+// dummyHash is compared against when the username doesn't exist, so that
+// unknown and known usernames take as long to reject.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type LoginPage struct {
+	CSRFToken   string
+	Next        string
+	Username    string
+	Error       string
+	TelegramBot string
+}
+
+// Auth logs admins in and guards the pages. Sessions are stored in the
+// database, keyed by the hash of a random cookie; each carries a CSRF token
+// that forms changing anything must send back.
+type Auth struct {
+	sessionTTL    time.Duration
+	secureCookies bool
+	// telegramBot is the username of the bot the Telegram Login Widget
+	// logs in with, empty when the widget is disabled.
+	telegramBot   string
+	telegramToken string
+}
+
+func newAuthFromEnv() (*Auth, error) {
+	ttl, err := time.ParseDuration(GetEnv("ADMIN_SESSION_TTL", "12h"))
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid ADMIN_SESSION_TTL: %q", GetEnv("ADMIN_SESSION_TTL", ""))
+	}
+	auth := &Auth{
+		sessionTTL:    ttl,
+		secureCookies: GetEnv("ADMIN_COOKIE_SECURE", "false") == "true",
+		telegramBot:   GetEnv("ADMIN_TELEGRAM_BOT", ""),
+		telegramToken: GetEnv("TELEGRAM_BOT_TOKEN", ""),
+	}
+	if auth.telegramBot != "" && auth.telegramToken == "" {
+		return nil, errors.New("ADMIN_TELEGRAM_BOT requires TELEGRAM_BOT_TOKEN")
+	}
+	return auth, nil
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokensEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// secure reports whether cookies should be limited to HTTPS: always when
+// ADMIN_COOKIE_SECURE is set, and when the request came over HTTPS, directly
+// or through a proxy.
+func (a *Auth) secure(r *http.Request) bool {
+	return a.secureCookies || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func (a *Auth) setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// session returns the session of the request's cookie.
+func (a *Auth) session(r *http.Request) (repository.AdminSession, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return repository.AdminSession{}, repository.ErrNotFound
+	}
+	return repo.AdminSession(hashToken(cookie.Value))
+}
+
+// requestSession returns the session Require stored in the request.
+func requestSession(r *http.Request) repository.AdminSession {
+	session, _ := r.Context().Value(sessionKey).(repository.AdminSession)
+	return session
+}
+
+// Require lets logged in admins through to h. Requests other than GET and
+// HEAD must also carry the session's CSRF token and come from an operator.
+func (a *Auth) Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := a.session(r)
+		if err != nil {
+			if err != repository.ErrNotFound {
+				logger.Printf("Error querying session: %v", err)
+			}
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !tokensEqual(session.CSRFToken, r.FormValue(csrfField)) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			if session.Account.Role != roleOperator {
+				http.Error(w, "Only operators can do this", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey, session)))
+	})
+}
+
+// startSession logs the account in and sends the browser on to next.
+func (a *Auth) startSession(w http.ResponseWriter, r *http.Request, account repository.AdminAccount, next string) {
+	if err := repo.DeleteExpiredAdminSessions(); err != nil {
+		logger.Printf("Error deleting expired sessions: %v", err)
+	}
+	token := randomToken()
+	err := repo.CreateAdminSession(repository.AdminSession{
+		ID:        hashToken(token),
+		Account:   account,
+		CSRFToken: randomToken(),
+		ExpiresAt: time.Now().Add(a.sessionTTL),
+	})
+	if err != nil {
+		logger.Printf("Error creating session: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("%s logged in as %s", account.Username, account.Role)
+	a.setCookie(w, r, sessionCookie, token, int(a.sessionTTL.Seconds()))
+	a.setCookie(w, r, loginCSRFCookie, "", -1)
+	http.Redirect(w, r, safeNext(next), http.StatusSeeOther)
+}
+
+// safeNext keeps redirects after login on this site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/statistics"
+	}
+	return next
+}
+
+// LoginHandler shows the login form and checks the password posted to it.
+// The form is protected by a CSRF token in a cookie, since there is no
+// session yet.
+func (a *Auth) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	page := LoginPage{Next: r.FormValue("next"), TelegramBot: a.telegramBot}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		cookie, err := r.Cookie(loginCSRFCookie)
+		if err != nil || !tokensEqual(cookie.Value, r.FormValue(csrfField)) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		page.Username = r.FormValue("username")
+		account, err := repo.AdminAccountByUsername(page.Username)
+		if err != nil && err != repository.ErrNotFound {
+			logger.Printf("Error querying admin account: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hash := []byte(account.PasswordHash)
+		if err != nil || len(hash) == 0 {
+			hash = dummyHash
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(r.FormValue("password"))) == nil && account.PasswordHash != "" {
+			a.startSession(w, r, account, page.Next)
+			return
+		}
+		logger.Printf("Failed login for %q from %s", page.Username, r.RemoteAddr)
+		page.Error = "Wrong username or password"
+		status = http.StatusUnauthorized
+	}
+
+	tmpl, err := loadTemplate(r, "login")
+	if err != nil {
+		logger.Printf("Error loading template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.CSRFToken = randomToken()
+	a.setCookie(w, r, loginCSRFCookie, page.CSRFToken, 0)
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, page); err != nil {
+		logger.Printf("Error executing template: %v", err)
+	}
+}
+
+// TelegramLoginHandler is where the Telegram Login Widget sends the user.
+// It logs in the account linked to their Telegram ID.
+func (a *Auth) TelegramLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if a.telegramBot == "" {
+		http.NotFound(w, r)
+		return
+	}
+	telegramID, err := a.verifyTelegramLogin(r.URL.Query(), time.Now())
+	if err != nil {
+		logger.Printf("Rejected Telegram login from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Invalid Telegram login", http.StatusForbidden)
+		return
+	}
+	account, err := repo.AdminAccountByTelegramID(telegramID)
+	if err == repository.ErrNotFound {
+		logger.Printf("Telegram user %d has no admin account", telegramID)
+		http.Error(w, "This Telegram account has no access", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error querying admin account: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.startSession(w, r, account, "")
+}
+
+// verifyTelegramLogin checks the signature of the data the Telegram Login
+// Widget passes (https://core.telegram.org/widgets/login#checking-authorization)
+// and returns the Telegram ID of the user.
+func (a *Auth) verifyTelegramLogin(values url.Values, now time.Time) (int64, error) {
+	hash := values.Get("hash")
+	var fields []string
+	for key := range values {
+		if key != "hash" {
+			fields = append(fields, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(fields)
+
+	secret := sha256.Sum256([]byte(a.telegramToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return 0, errors.New("bad signature")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > telegramAuthMaxAge {
+		return 0, errors.New("login data expired")
+	}
+	return strconv.ParseInt(values.Get("id"), 10, 64)
+}
+
+// LogoutHandler ends the session. Like the other forms, it needs the CSRF
+// token, but viewers may use it.
+func (a *Auth) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := a.session(r)
+	if err == nil {
+		if !tokensEqual(session.CSRFToken, r.FormValue(csrfField)) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if err := repo.DeleteAdminSession(session.ID); err != nil {
+			logger.Printf("Error deleting session: %v", err)
+		}
+	}
+	a.setCookie(w, r, sessionCookie, "", -1)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// createAccountCommand implements `admin create-account <username> <role>
+// [telegram id]`. The password is read from the first line of stdin; an
+// empty one only allows logging in with Telegram. Running it again for the
+// same username replaces the account's password, role and Telegram ID.
+func createAccountCommand(args []string) error {
+	usage := errors.New("usage: admin create-account <username> <viewer|operator> [telegram id] < password")
+	if len(args) < 2 || len(args) > 3 {
+		return usage
+	}
+	account := repository.AdminAccount{Username: args[0], Role: args[1]}
+	if account.Username == "" || (account.Role != roleViewer && account.Role != roleOperator) {
+		return usage
+	}
+	if len(args) == 3 {
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return usage
+		}
+		account.TelegramID = id
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" && account.TelegramID == 0 {
+		return errors.New("no password on stdin")
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" && account.TelegramID == 0 {
+		return errors.New("an account without a password needs a Telegram ID")
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		account.PasswordHash = string(hash)
+	}
+	if err := repo.SaveAdminAccount(account); err != nil {
+		return err
+	}
+	log.Printf("Saved admin account %s (%s)", account.Username, account.Role)
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+// exportFlushRows is how many rows are written between flushes, so exports
+// reach the client while they are read.
+const exportFlushRows = 500
+
+// The columns of each export. The row functions return the values of a row
+// in the same order.
+var (
+	downloadExportColumns = []string{"id", "user_id", "username", "first_name", "last_name", "url", "timestamp",
+		"file_size", "preview_image", "tags", "description", "platform", "uploader"}
+	userExportColumns = []string{"user_id", "username", "first_name", "last_name", "downloads", "bytes", "last_download"}
+)
+
+func downloadExportRow(d repository.UserDownload) []interface{} {
+	return []interface{}{d.ID, d.UserID, d.Username, d.FirstName, d.LastName, d.URL, d.Timestamp,
+		d.FileSize, d.PreviewImage, d.Tags, d.Description, d.Platform, d.Uploader}
+}
+
+func userExportRow(u repository.UserStats) []interface{} {
+	return []interface{}{u.UserID, u.Username, u.FirstName, u.LastName, u.Downloads, u.Bytes, u.LastDownload}
+}
+
+// selectColumns returns the indexes in all of the columns named in the
+// columns parameters, comma separated or repeated, in their order. Without
+// any, all columns are exported.
+func selectColumns(all []string, r *http.Request) ([]int, error) {
+	var indexes []int
+	for _, name := range strings.Split(strings.Join(r.URL.Query()["columns"], ","), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		index := -1
+		for i, column := range all {
+			if column == name {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("unknown column %q; the columns are %s", name, strings.Join(all, ", "))
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		for i := range all {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// exportWriter writes the rows of an export as CSV, with a header row, or
+// as JSON Lines, one object per row with the columns in order.
+type exportWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	jsonl   *bufio.Writer
+	names   []string
+	indexes []int
+	rows    int
+}
+
+func newExportWriter(w http.ResponseWriter, format, name string, all []string, indexes []int) (*exportWriter, error) {
+	e := &exportWriter{w: w, indexes: indexes}
+	for _, i := range indexes {
+		e.names = append(e.names, all[i])
+	}
+
+	filename := name + "-" + time.Now().UTC().Format("20060102")
+	switch format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		filename += ".csv"
+		e.csv = csv.NewWriter(w)
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/jsonl; charset=utf-8")
+		filename += ".jsonl"
+		e.jsonl = bufio.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown format %q; use csv or jsonl", format)
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if e.csv != nil {
+		return e, e.csv.Write(e.names)
+	}
+	return e, nil
+}
+
+// csvCell prefixes text a spreadsheet would run as a formula with a quote,
+// so user-controlled descriptions and tags can't inject formulas.
+func csvCell(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func (e *exportWriter) write(row []interface{}) error {
+	if e.csv != nil {
+		record := make([]string, len(e.indexes))
+		for i, index := range e.indexes {
+			record[i] = fmt.Sprint(row[index])
+			if _, ok := row[index].(string); ok {
+				record[i] = csvCell(record[i])
+			}
+		}
+		if err := e.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		// Written by hand, as a map would lose the order of the columns.
+		e.jsonl.WriteByte('{')
+		for i, index := range e.indexes {
+			if i > 0 {
+				e.jsonl.WriteByte(',')
+			}
+			key, _ := json.Marshal(e.names[i])
+			value, err := json.Marshal(row[index])
+			if err != nil {
+				return err
+			}
+			e.jsonl.Write(key)
+			e.jsonl.WriteByte(':')
+			e.jsonl.Write(value)
+		}
+		if _, err := e.jsonl.WriteString("}\n"); err != nil {
+			return err
+		}
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exportWriter) flush() error {
+	var err error
+	if e.csv != nil {
+		e.csv.Flush()
+		err = e.csv.Error()
+	} else {
+		err = e.jsonl.Flush()
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
+
+// export streams the rows each passes to its callback, in the format (csv,
+// the default, or jsonl) and with the columns the request asks for. Once
+// rows are written, errors can't change the response any more; they are
+// logged and cut it short.
+func export(w http.ResponseWriter, r *http.Request, name string, all []string, each func(write func([]interface{}) error) error) {
+	indexes, err := selectColumns(all, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out, err := newExportWriter(w, r.URL.Query().Get("format"), name, all, indexes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := each(out.write); err != nil {
+		logger.Printf("Error exporting %s after %d rows: %v", name, out.rows, err)
+		return
+	}
+	if err := out.flush(); err != nil {
+		logger.Printf("Error exporting %s: %v", name, err)
+		return
+	}
+	logger.Printf("%s exported %d %s", requestSession(r).Account.Username, out.rows, name)
+}
+
+// exportDownloadsHandler exports the downloads matching the filters of the
+// downloads page, oldest first.
+func exportDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _, _, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export(w, r, "downloads", downloadExportColumns, func(write func([]interface{}) error) error {
+		return repo.EachDownload(filter, func(d repository.UserDownload) error {
+			return write(downloadExportRow(d))
+		})
+	})
+}
+
+// exportUsersHandler exports the users by Telegram ID. With filters, only
+// the users with matching downloads are exported, and only those count.
+func exportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _, _, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export(w, r, "users", userExportColumns, func(write func([]interface{}) error) error {
+		return repo.EachUser(filter, func(u repository.UserStats) error {
+			return write(userExportRow(u))
+		})
+	})
+}
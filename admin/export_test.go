@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestExportCSVFormulas checks that text cells a spreadsheet would evaluate
+// are exported quoted, and other cells as they are.
+func TestExportCSVFormulas(t *testing.T) {
+	rec := httptest.NewRecorder()
+	columns := []string{"id", "tags", "description"}
+	e, err := newExportWriter(rec, "csv", "test", columns, []int{0, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]interface{}{
+		{1, "=HYPERLINK(\"http://evil\")", "+1"},
+		{-2, "-cats", "@dogs"},
+		{3, "\tcats", "\rdogs"},
+		{4, "cats, dogs", "a = b"},
+	} {
+		if err := e.write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.csv.Flush()
+
+	want := `id,tags,description
+1,"'=HYPERLINK(""http://evil"")",'+1
+-2,'-cats,'@dogs
+3,'	cats,"'` + "\r" + `dogs"
+4,"cats, dogs",a = b
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", strings.ReplaceAll(got, "\r", `\r`), strings.ReplaceAll(want, "\r", `\r`))
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+)
+
+const dateLayout = "2006-01-02"
+
+// ListPage is the data of the list pages. The filter fields hold the query
+// string as given, to fill the filter form back in.
+type ListPage struct {
+	Rows      interface{}
+	Platforms []*platform.Platform
+
+	Platform    string
+	User        string
+	Tag         string
+	From        string
+	To          string
+	MinSize     string
+	MaxSize     string
+	ContentHash string
+
+	Sort     string
+	Desc     bool
+	Page     int
+	PageSize int
+	Total    int
+	Pages    int
+	// PrevPage and NextPage are 0 on the first and last page.
+	PrevPage int
+	NextPage int
+}
+
+// parseListFilter reads the filters the list pages and the API share from
+// the query string: platform, user (a Telegram ID or username), tag, from
+// and to (dates), and min_size and max_size, in units of sizeUnit bytes.
+func parseListFilter(q url.Values, sizeUnit float64) (repository.ListFilter, error) {
+	filter := repository.ListFilter{Platform: q.Get("platform"), Tag: strings.TrimSpace(q.Get("tag"))}
+	var err error
+
+	if user := strings.TrimSpace(q.Get("user")); user != "" {
+		if id, err := strconv.ParseInt(user, 10, 64); err == nil {
+			filter.UserID = id
+		} else {
+			filter.Username = user
+		}
+	}
+	for _, d := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := q.Get(d.name)
+		if value == "" {
+			continue
+		}
+		*d.target, err = time.Parse(dateLayout, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s date %q", d.name, value)
+		}
+	}
+	for _, s := range []struct {
+		name   string
+		target *int64
+	}{{"min_size", &filter.MinSize}, {"max_size", &filter.MaxSize}} {
+		value := q.Get(s.name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.ParseFloat(value, 64)
+		if err != nil || size < 0 {
+			return filter, fmt.Errorf("invalid %s %q", s.name, value)
+		}
+		*s.target = int64(size * sizeUnit)
+	}
+	return filter, nil
+}
+
+// parseListQuery reads the filters, sort order and page of a list page from
+// the query string: those of parseListFilter, with sizes in MB, and sort,
+// order (asc or desc), page and per_page.
+func parseListQuery(r *http.Request) (repository.ListFilter, repository.Page, ListPage, error) {
+	q := r.URL.Query()
+	page := ListPage{
+		Platforms:   platform.All(),
+		Platform:    q.Get("platform"),
+		User:        strings.TrimSpace(q.Get("user")),
+		Tag:         strings.TrimSpace(q.Get("tag")),
+		From:        q.Get("from"),
+		To:          q.Get("to"),
+		MinSize:     q.Get("min_size"),
+		MaxSize:     q.Get("max_size"),
+		ContentHash: q.Get("content_hash"),
+		Sort:        q.Get("sort"),
+		Desc:        q.Get("order") == "desc",
+	}
+	filter, err := parseListFilter(q, 1024*1024)
+	if err != nil {
+		return filter, repository.Page{}, page, err
+	}
+
+	p := repository.Page{Sort: page.Sort, Desc: page.Desc, Number: 1, Size: repository.DefaultPageSize}
+	if v := q.Get("page"); v != "" {
+		if p.Number, err = strconv.Atoi(v); err != nil || p.Number < 1 {
+			return filter, p, page, fmt.Errorf("invalid page %q", v)
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if p.Size, err = strconv.Atoi(v); err != nil || p.Size < 1 || p.Size > repository.MaxPageSize {
+			return filter, p, page, fmt.Errorf("invalid per_page %q", v)
+		}
+	}
+	page.Page, page.PageSize = p.Number, p.Size
+	return filter, p, page, nil
+}
+
+// setTotal fills in the page count once the number of matching rows is
+// known.
+func (page *ListPage) setTotal(total int) {
+	page.Total = total
+	page.Pages = (total + page.PageSize - 1) / page.PageSize
+	if page.Page > 1 {
+		page.PrevPage = page.Page - 1
+	}
+	if page.Page < page.Pages {
+		page.NextPage = page.Page + 1
+	}
+}
+
+// queryURL returns the query string of r with the given key and value pairs
+// set, or removed when the value is empty, so links keep the other filters.
+func queryURL(r *http.Request, pairs ...string) string {
+	q := r.URL.Query()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			q.Del(pairs[i])
+		} else {
+			q.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return "?" + q.Encode()
+}
+
+// sortURL returns the query string that sorts by key, ascending first and
+// descending when it already sorts by key ascending. It goes back to the
+// first page.
+func sortURL(r *http.Request, key string) string {
+	q := r.URL.Query()
+	order := "asc"
+	if q.Get("sort") == key && q.Get("order") != "desc" {
+		order = "desc"
+	}
+	return queryURL(r, "sort", key, "order", order, "page", "")
+}
+
+// SortIcon marks the column the page is sorted by. Without a sort, pages
+// show the newest rows first.
+func (page ListPage) SortIcon(key string) string {
+	switch {
+	case page.Sort == key && page.Desc, page.Sort == "" && key == "timestamp":
+		return " ▼"
+	case page.Sort == key:
+		return " ▲"
+	}
+	return ""
+}
+
+// PageSizes are the choices of the per page select, including the current
+// size when it was given in the URL.
+func (page ListPage) PageSizes() []int {
+	sizes := []int{25, repository.DefaultPageSize, 100, 200}
+	for _, size := range sizes {
+		if size == page.PageSize {
+			return sizes
+		}
+	}
+	return append(sizes, page.PageSize)
+}
@@ -1,18 +1,31 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
-	"text/template"
-
-	_ "github.com/mattn/go-sqlite3"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/janitor"
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
 )
 
 var (
 	databaseFile = "/app/data/videos.db"
 	logger       *log.Logger
+	repo         *repository.Repository
 )
 
 func init() {
@@ -26,201 +39,278 @@ func init() {
 	logger = log.New(logFile, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
 }
 
-func initDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", databaseFile)
-	if err != nil {
-		logger.Printf("Failed to connect to database: %v", err)
-		return nil, err
-	}
-	return db, nil
-}
-
-type ProcessedURL struct {
-	URL          string
-	Timestamp    string
-	FileSize     int64
-	PreviewImage string
-	Tags         string
-	Description  string
-}
-
-type UserDownload struct {
-	UserID       int64
-	Username     string
-	FirstName    string
-	LastName     string
-	URL          string
-	Timestamp    string
-	FileSize     int64
-	PreviewImage string
-	Tags         string
-	Description  string
+// JanitorPage lists the janitor runs. Actions are those of the selected run,
+// or of the run just started from the page.
+type JanitorPage struct {
+	Config   janitor.Config
+	Runs     []repository.JanitorRun
+	Selected int64
+	Actions  []janitor.Action
+	Error    string
 }
 
-type Statistics struct {
-	TotalFileSize  int64
-	TotalDownloads int
-	UserDownloads  []UserDownload
-}
-
-func loadTemplate(name string) (*template.Template, error) {
-	return template.ParseFiles("templates/" + name + ".html")
+// loadTemplate parses the page along with templates/account.html, which
+// shows who is logged in, and templates/list.html, the filters, pagination
+// and export forms of list pages. Pages get the session of the request
+// through the session and canOperate functions, links to the same page with
+// other query parameters through query and sortURL, and the columns of each
+// export through exportColumns. Search snippets, which the repository has
+// already escaped, are marked safe with highlighted.
+func loadTemplate(r *http.Request, name string) (*template.Template, error) {
+	session := requestSession(r)
+	return template.New(name+".html").Funcs(template.FuncMap{
+		"session":    func() repository.AdminSession { return session },
+		"canOperate": func() bool { return session.Account.Role == roleOperator },
+		"query":      func(pairs ...string) string { return queryURL(r, pairs...) },
+		"sortURL":    func(key string) string { return sortURL(r, key) },
+		"exportColumns": func() map[string][]string {
+			return map[string][]string{"downloads": downloadExportColumns, "users": userExportColumns}
+		},
+		"highlighted": func(snippet string) template.HTML { return template.HTML(snippet) },
+	}).ParseFiles("templates/"+name+".html", "templates/account.html", "templates/list.html")
 }
 
 func processedURLsHandler(w http.ResponseWriter, r *http.Request) {
-	db, err := initDB()
+	filter, p, page, err := parseListQuery(r)
 	if err != nil {
-		logger.Printf("Error initializing DB: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer db.Close()
-
-	rows, err := db.Query("SELECT DISTINCT url, timestamp, file_size, preview_image, tags, description FROM processed_urls")
+	processedURLs, total, err := repo.ListProcessedURLs(repository.ProcessedURLFilter{ListFilter: filter, ContentHash: page.ContentHash}, p)
 	if err != nil {
 		logger.Printf("Error querying processed URLs: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var processedURLs []ProcessedURL
-	for rows.Next() {
-		var url ProcessedURL
-		if err := rows.Scan(&url.URL, &url.Timestamp, &url.FileSize, &url.PreviewImage, &url.Tags, &url.Description); err != nil {
-			logger.Printf("Error scanning row: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		processedURLs = append(processedURLs, url)
-	}
 
-	tmpl, err := loadTemplate("processed_urls")
+	tmpl, err := loadTemplate(r, "processed_urls")
 	if err != nil {
 		logger.Printf("Error loading template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tmpl.Execute(w, processedURLs); err != nil {
+	page.Rows = processedURLs
+	page.setTotal(total)
+	if err := tmpl.Execute(w, page); err != nil {
 		logger.Printf("Error executing template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func userDownloadsHandler(w http.ResponseWriter, r *http.Request) {
-	db, err := initDB()
+	filter, p, page, err := parseListQuery(r)
 	if err != nil {
-		logger.Printf("Error initializing DB: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer db.Close()
-
-	rows, err := db.Query(`
-		SELECT DISTINCT u.user_id, u.username, u.first_name, u.last_name, d.url, d.timestamp, d.file_size, d.preview_image, d.tags, d.description
-		FROM downloads d
-		JOIN users u ON d.user_id = u.user_id
-	`)
+	userDownloads, total, err := repo.ListDownloads(filter, p)
 	if err != nil {
 		logger.Printf("Error querying user downloads: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var userDownloads []UserDownload
-	for rows.Next() {
-		var download UserDownload
-		if err := rows.Scan(&download.UserID, &download.Username, &download.FirstName, &download.LastName, &download.URL, &download.Timestamp, &download.FileSize, &download.PreviewImage, &download.Tags, &download.Description); err != nil {
-			logger.Printf("Error scanning row: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		userDownloads = append(userDownloads, download)
-	}
-
-	tmpl, err := loadTemplate("user_downloads")
+	tmpl, err := loadTemplate(r, "user_downloads")
 	if err != nil {
 		logger.Printf("Error loading template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tmpl.Execute(w, userDownloads); err != nil {
+	page.Rows = userDownloads
+	page.setTotal(total)
+	if err := tmpl.Execute(w, page); err != nil {
 		logger.Printf("Error executing template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func statisticsHandler(w http.ResponseWriter, r *http.Request) {
-	db, err := initDB()
-	if err != nil {
-		logger.Printf("Error initializing DB: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func broadcastsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		createBroadcast(w, r)
 		return
 	}
-	defer db.Close()
-
-	var totalFileSize int64
-	var totalDownloads int
 
-	err = db.QueryRow("SELECT SUM(file_size) FROM processed_urls").Scan(&totalFileSize)
+	broadcasts, err := repo.ListBroadcasts()
 	if err != nil {
-		logger.Printf("Error querying total file size: %v", err)
+		logger.Printf("Error querying broadcasts: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = db.QueryRow("SELECT COUNT(DISTINCT url) FROM processed_urls").Scan(&totalDownloads)
+	tmpl, err := loadTemplate(r, "broadcasts")
 	if err != nil {
-		logger.Printf("Error querying total downloads: %v", err)
+		logger.Printf("Error loading template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	rows, err := db.Query(`
-		SELECT DISTINCT u.user_id, u.username, u.first_name, u.last_name, d.url, d.timestamp, d.file_size, d.preview_image, d.tags, d.description
-		FROM downloads d
-		JOIN users u ON d.user_id = u.user_id
-	`)
-	if err != nil {
-		logger.Printf("Error querying user downloads: %v", err)
+	if err := tmpl.Execute(w, broadcasts); err != nil {
+		logger.Printf("Error executing template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func createBroadcast(w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("kind")
+	text := r.FormValue("text")
+	media := r.FormValue("media")
+	segment := r.FormValue("segment")
+
+	switch kind {
+	case "text":
+		if text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+	case "photo", "video":
+		if media == "" {
+			http.Error(w, "Media URL or file_id is required", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Unknown broadcast kind", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	var userDownloads []UserDownload
-	for rows.Next() {
-		var download UserDownload
-		if err := rows.Scan(&download.UserID, &download.Username, &download.FirstName, &download.LastName, &download.URL, &download.Timestamp, &download.FileSize, &download.PreviewImage, &download.Tags, &download.Description); err != nil {
-			logger.Printf("Error scanning row: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	segmentDays := 0
+	switch segment {
+	case "all":
+	case "active", "inactive":
+		days, err := strconv.Atoi(r.FormValue("segment_days"))
+		if err != nil || days <= 0 {
+			http.Error(w, "Segment days must be a positive number", http.StatusBadRequest)
 			return
 		}
-		userDownloads = append(userDownloads, download)
+		segmentDays = days
+	default:
+		http.Error(w, "Unknown segment", http.StatusBadRequest)
+		return
+	}
+
+	err := repo.CreateBroadcast(repository.Broadcast{Kind: kind, Text: text, Media: media, Segment: segment, SegmentDays: segmentDays})
+	if err != nil {
+		logger.Printf("Error creating broadcast: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	statistics := Statistics{
-		TotalFileSize:  totalFileSize,
-		TotalDownloads: totalDownloads,
-		UserDownloads:  userDownloads,
+	http.Redirect(w, r, "/broadcasts", http.StatusSeeOther)
+}
+
+func proxiesHandler(w http.ResponseWriter, r *http.Request) {
+	proxies, err := repo.ListProxyStats()
+	if err != nil {
+		logger.Printf("Error querying proxy stats: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	tmpl, err := loadTemplate("statistics")
+	tmpl, err := loadTemplate(r, "proxies")
 	if err != nil {
 		logger.Printf("Error loading template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tmpl.Execute(w, statistics); err != nil {
+	if err := tmpl.Execute(w, proxies); err != nil {
 		logger.Printf("Error executing template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// mediaHandler serves stored objects by key: it redirects to a signed URL
+// when the storage backend has them, and streams the object otherwise.
+func mediaHandler(st storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		url, err := st.SignedURL(r.Context(), key, 15*time.Minute)
+		if err == nil {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+		if !errors.Is(err, storage.ErrNoURL) {
+			logger.Printf("Error signing URL for %s: %v", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		object, err := st.Get(r.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Printf("Error reading %s: %v", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer object.Close()
+
+		if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if _, err := io.Copy(w, object); err != nil {
+			logger.Printf("Error serving %s: %v", key, err)
+		}
+	})
+}
+
+// janitorHandler shows the janitor reports. POST runs the janitor right away,
+// as a dry run unless mode is "delete".
+func janitorHandler(st storage.Storage, config janitor.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := JanitorPage{Config: config}
+		if r.Method == http.MethodPost {
+			dryRun := r.FormValue("mode") != "delete"
+			report, err := janitor.Run(r.Context(), repo.DB(), st, config, "admin", dryRun)
+			if err != nil {
+				logger.Printf("Error running janitor: %v", err)
+				page.Error = err.Error()
+			}
+			if err := janitor.Save(repo.DB(), report); err != nil {
+				logger.Printf("Error saving janitor report: %v", err)
+			}
+			page.Actions = report.Actions
+		} else if id, err := strconv.ParseInt(r.URL.Query().Get("run"), 10, 64); err == nil {
+			details, err := repo.JanitorRunDetails(id)
+			if err != nil && err != repository.ErrNotFound {
+				logger.Printf("Error querying janitor run: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				page.Selected = id
+				if err := json.Unmarshal([]byte(details), &page.Actions); err != nil {
+					logger.Printf("Error parsing janitor run %d: %v", id, err)
+				}
+			}
+		}
+
+		runs, err := repo.ListJanitorRuns(50)
+		if err != nil {
+			logger.Printf("Error querying janitor runs: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Runs = runs
+
+		tmpl, err := loadTemplate(r, "janitor")
+		if err != nil {
+			logger.Printf("Error loading template: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.Execute(w, page); err != nil {
+			logger.Printf("Error executing template: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
 func serveStaticFiles(directory string, allowDirListing bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !allowDirListing && r.URL.Path == "/" {
@@ -245,9 +335,67 @@ func main() {
 
 	allowDirListing := GetEnv("ALLOW_DIR_LISTING", "false") == "true"
 
-	http.HandleFunc("/processed_urls", processedURLsHandler)
-	http.HandleFunc("/user_downloads", userDownloadsHandler)
-	http.HandleFunc("/statistics", statisticsHandler)
-	http.Handle("/static/", http.StripPrefix("/static", serveStaticFiles(downloadDir, allowDirListing)))
+	var err error
+	repo, err = repository.Open(databaseFile)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer repo.Close()
+	if err := migrations.Wait(context.Background(), repo.DB()); err != nil {
+		log.Fatalf("Failed to wait for database migrations: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "create-account":
+			if err := createAccountCommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "create-api-key", "list-api-keys", "revoke-api-key":
+			if err := apiKeyCommand(os.Args[1], os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+	}
+
+	auth, err := newAuthFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	st, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	janitorConfig, err := janitor.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tiers, err := quota.FromEnv(GetEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/login", auth.LoginHandler)
+	http.HandleFunc("/login/telegram", auth.TelegramLoginHandler)
+	http.HandleFunc("/logout", auth.LogoutHandler)
+	http.Handle("/processed_urls", auth.Require(http.HandlerFunc(processedURLsHandler)))
+	http.Handle("/user_downloads", auth.Require(http.HandlerFunc(userDownloadsHandler)))
+	http.Handle("/users/{id}", auth.Require(userHandler(tiers)))
+	http.Handle("/statistics", auth.Require(http.HandlerFunc(statisticsHandler)))
+	http.Handle("/broadcasts", auth.Require(http.HandlerFunc(broadcastsHandler)))
+	http.Handle("/proxies", auth.Require(http.HandlerFunc(proxiesHandler)))
+	http.Handle("/search", auth.Require(http.HandlerFunc(searchHandler)))
+	http.Handle("/export/downloads", auth.Require(http.HandlerFunc(exportDownloadsHandler)))
+	http.Handle("/export/users", auth.Require(http.HandlerFunc(exportUsersHandler)))
+	http.Handle("/search.json", auth.Require(http.HandlerFunc(searchJSONHandler)))
+	http.Handle("/static/", auth.Require(http.StripPrefix("/static", serveStaticFiles(downloadDir, allowDirListing))))
+	http.Handle("/media/", auth.Require(http.StripPrefix("/media", mediaHandler(st))))
+	http.Handle("/janitor", auth.Require(janitorHandler(st, janitorConfig)))
+	registerAPI(auth)
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+// SearchPage is the data of the search page. The pagination fields of
+// ListPage are used; its filters are not.
+type SearchPage struct {
+	ListPage
+	Query string
+}
+
+// searchResponse is the body of /search.json. The snippets are HTML, with
+// the matches in <mark> tags.
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Results []searchResult `json:"results"`
+}
+
+type searchResult struct {
+	URL                string `json:"url"`
+	Timestamp          string `json:"timestamp"`
+	FileSize           int64  `json:"file_size"`
+	PreviewImage       string `json:"preview_image,omitempty"`
+	Platform           string `json:"platform"`
+	ContentHash        string `json:"content_hash,omitempty"`
+	DescriptionSnippet string `json:"description_snippet"`
+	TagsSnippet        string `json:"tags_snippet"`
+	UploaderSnippet    string `json:"uploader_snippet"`
+}
+
+// search runs the search in the q parameter, paged like the list pages.
+func search(w http.ResponseWriter, r *http.Request) (SearchPage, []repository.SearchResult, bool) {
+	_, p, list, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return SearchPage{}, nil, false
+	}
+	page := SearchPage{ListPage: list, Query: r.URL.Query().Get("q")}
+	results, total, err := repo.Search(page.Query, p)
+	if errors.Is(err, repository.ErrSearchUnavailable) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return page, nil, false
+	}
+	if err != nil {
+		logger.Printf("Error searching for %q: %v", page.Query, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return page, nil, false
+	}
+	page.setTotal(total)
+	return page, results, true
+}
+
+// searchHandler shows the processed URLs whose description, tags or
+// uploader match the query.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	page, results, ok := search(w, r)
+	if !ok {
+		return
+	}
+	page.Rows = results
+
+	tmpl, err := loadTemplate(r, "search")
+	if err != nil {
+		logger.Printf("Error loading template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, page); err != nil {
+		logger.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// searchJSONHandler is searchHandler for scripts.
+func searchJSONHandler(w http.ResponseWriter, r *http.Request) {
+	page, results, ok := search(w, r)
+	if !ok {
+		return
+	}
+	response := searchResponse{Query: page.Query, Total: page.Total, Page: page.Page, PerPage: page.PageSize, Results: []searchResult{}}
+	for _, s := range results {
+		response.Results = append(response.Results, searchResult(s))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Printf("Error encoding search results: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+)
+
+// defaultStatsDays is the range of the statistics when none is given.
+const defaultStatsDays = 30
+
+// StatsPage is the data of the statistics page. Its charts and tables are
+// filled in from the /api/v1/statistics endpoints, with the same query.
+type StatsPage struct {
+	Platforms []*platform.Platform
+	Platform  string
+	From      string
+	To        string
+	Interval  string
+}
+
+// parseStatsQuery reads the range of the statistics from the query string:
+// from and to (dates, the last 30 days by default), platform, user (a
+// Telegram ID) and interval (day, the default, or week).
+func parseStatsQuery(q url.Values) (repository.StatsFilter, string, error) {
+	filter := repository.StatsFilter{Platform: q.Get("platform")}
+	var err error
+	if v := q.Get("user"); v != "" {
+		if filter.UserID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return filter, "", fmt.Errorf("invalid user %q", v)
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(dateLayout, v); err != nil {
+			return filter, "", fmt.Errorf("invalid from date %q", v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(dateLayout, v); err != nil {
+			return filter, "", fmt.Errorf("invalid to date %q", v)
+		}
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now().UTC().Truncate(24 * time.Hour)
+		if !filter.From.IsZero() && filter.From.After(filter.To) {
+			filter.To = filter.From
+		}
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.AddDate(0, 0, 1-defaultStatsDays)
+	}
+	if filter.From.After(filter.To) {
+		return filter, "", fmt.Errorf("from date %s is after to date %s", filter.From.Format(dateLayout), filter.To.Format(dateLayout))
+	}
+
+	interval := q.Get("interval")
+	switch interval {
+	case "":
+		interval = repository.Day
+	case repository.Day, repository.Week:
+	default:
+		return filter, "", fmt.Errorf("invalid interval %q", interval)
+	}
+	return filter, interval, nil
+}
+
+// statisticsHandler shows the statistics page. Only the range form is
+// rendered here; the page fetches the numbers.
+func statisticsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, interval, err := parseStatsQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := StatsPage{
+		Platforms: platform.All(),
+		Platform:  filter.Platform,
+		From:      filter.From.Format(dateLayout),
+		To:        filter.To.Format(dateLayout),
+		Interval:  interval,
+	}
+
+	tmpl, err := loadTemplate(r, "statistics")
+	if err != nil {
+		logger.Printf("Error loading template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, page); err != nil {
+		logger.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type (
+	apiSummary struct {
+		From            string  `json:"from"`
+		To              string  `json:"to"`
+		Downloads       int     `json:"downloads"`
+		Bytes           int64   `json:"bytes"`
+		AverageFileSize float64 `json:"average_file_size"`
+		ActiveUsers     int     `json:"active_users"`
+		URLs            int     `json:"urls"`
+		Failures        int     `json:"failures"`
+		FailureRate     float64 `json:"failure_rate"`
+	}
+
+	apiSeriesPoint struct {
+		Period      string `json:"period"`
+		Downloads   int    `json:"downloads"`
+		Bytes       int64  `json:"bytes"`
+		ActiveUsers int    `json:"active_users"`
+	}
+
+	apiUploaderCount struct {
+		Uploader  string `json:"uploader"`
+		Downloads int    `json:"downloads"`
+		Bytes     int64  `json:"bytes"`
+	}
+
+	apiTop struct {
+		Users     []apiUser          `json:"users"`
+		Tags      []apiTagCount      `json:"tags"`
+		Uploaders []apiUploaderCount `json:"uploaders"`
+	}
+
+	apiFailureCount struct {
+		ErrorClass string  `json:"error_class"`
+		Count      int     `json:"count"`
+		Rate       float64 `json:"rate"`
+	}
+
+	apiFailures struct {
+		// Attempts is the number of downloads and failures; the rates
+		// are shares of it.
+		Attempts int               `json:"attempts"`
+		Classes  []apiFailureCount `json:"classes"`
+	}
+)
+
+// apiStatsQuery reads the range of a statistics endpoint, answering 400
+// when it is invalid.
+func apiStatsQuery(w http.ResponseWriter, r *http.Request) (repository.StatsFilter, string, bool) {
+	filter, interval, err := parseStatsQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return filter, interval, false
+	}
+	return filter, interval, true
+}
+
+func apiStatsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _, ok := apiStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	s, err := repo.DownloadSummary(filter)
+	if err != nil {
+		logger.Printf("Error querying download summary: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, apiSummary{
+		From:            filter.From.Format(dateLayout),
+		To:              filter.To.Format(dateLayout),
+		Downloads:       s.Downloads,
+		Bytes:           s.Bytes,
+		AverageFileSize: s.AverageSize,
+		ActiveUsers:     s.ActiveUsers,
+		URLs:            s.URLs,
+		Failures:        s.Failures,
+		FailureRate:     s.FailureRate(),
+	})
+}
+
+// apiStatsSeriesHandler returns the downloads, bytes and active users of
+// each day or week of the range.
+func apiStatsSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, interval, ok := apiStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	points, err := repo.DownloadSeries(filter, interval)
+	if err != nil {
+		logger.Printf("Error querying download series: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := []apiSeriesPoint{}
+	for _, p := range points {
+		data = append(data, apiSeriesPoint{Period: p.Period, Downloads: p.Downloads, Bytes: p.Bytes, ActiveUsers: p.ActiveUsers})
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// apiStatsTopHandler returns the top users, tags and uploaders of the
+// range, limit (10 by default) of each.
+func apiStatsTopHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _, ok := apiStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 100 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", v))
+			return
+		}
+	}
+
+	users, err := repo.TopUsers(filter, limit)
+	if err != nil {
+		logger.Printf("Error querying top users: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tags, err := repo.TopTags(filter, limit)
+	if err != nil {
+		logger.Printf("Error querying top tags: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	uploaders, err := repo.TopUploaders(filter, limit)
+	if err != nil {
+		logger.Printf("Error querying top uploaders: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	top := apiTop{Users: []apiUser{}, Tags: []apiTagCount{}, Uploaders: []apiUploaderCount{}}
+	for _, u := range users {
+		top.Users = append(top.Users, newAPIUser(u))
+	}
+	for _, t := range tags {
+		top.Tags = append(top.Tags, apiTagCount{Tag: t.Tag, Count: t.Count})
+	}
+	for _, u := range uploaders {
+		top.Uploaders = append(top.Uploaders, apiUploaderCount{Uploader: u.Uploader, Downloads: u.Downloads, Bytes: u.Bytes})
+	}
+	writeJSON(w, http.StatusOK, top)
+}
+
+// apiStatsFailuresHandler returns the failed downloads of the range by
+// error class, with their share of all download attempts.
+func apiStatsFailuresHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _, ok := apiStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	s, err := repo.DownloadSummary(filter)
+	if err != nil {
+		logger.Printf("Error querying download summary: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	counts, err := repo.FailuresByClass(filter)
+	if err != nil {
+		logger.Printf("Error querying failures: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	failures := apiFailures{Attempts: s.Downloads + s.Failures, Classes: []apiFailureCount{}}
+	for _, c := range counts {
+		failures.Classes = append(failures.Classes, apiFailureCount{ErrorClass: c.ErrorClass, Count: c.Count,
+			Rate: float64(c.Count) / float64(failures.Attempts)})
+	}
+	writeJSON(w, http.StatusOK, failures)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instaVideoDownloaderBot/janitor"
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+)
+
+// payload is stored wherever users, platforms or proxies control the text.
+const payload = `<script>alert(1)</script>`
+
+func testRepository(t *testing.T) *repository.Repository {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	r, err := repository.Open(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if err := migrations.Up(r.DB()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestPagesEscape renders every page with the payload in the database and
+// checks that it only ever comes out escaped.
+func TestPagesEscape(t *testing.T) {
+	repo = testRepository(t)
+	user := repository.User{UserID: 7, Username: payload, FirstName: payload, LastName: payload}
+	err := repo.RecordDownload(repository.Download{
+		User:        user,
+		URL:         "https://www.instagram.com/reel/x/?" + payload,
+		Size:        100,
+		Tags:        payload,
+		Description: payload,
+		Platform:    "instagram",
+		Uploader:    payload,
+		ContentHash: "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.BanUser(user.UserID, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBroadcast(repository.Broadcast{Kind: "text", Text: payload, Segment: "all"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RecordProxyResult("http://proxy:8080", payload); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	report := &janitor.Report{StartedAt: now, FinishedAt: now, Trigger: "test", DryRun: true,
+		Actions: []janitor.Action{{Reason: payload, Key: payload, LastUsed: now, Error: payload}}}
+	if err := janitor.Save(repo.DB(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &Auth{telegramBot: payload}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", auth.LoginHandler)
+	mux.HandleFunc("/processed_urls", processedURLsHandler)
+	mux.HandleFunc("/user_downloads", userDownloadsHandler)
+	mux.Handle("/users/{id}", userHandler(quota.Tiers{{Name: quota.TierFree}}))
+	mux.HandleFunc("/statistics", statisticsHandler)
+	mux.HandleFunc("/broadcasts", broadcastsHandler)
+	mux.HandleFunc("/proxies", proxiesHandler)
+	mux.HandleFunc("/search", searchHandler)
+	mux.Handle("/janitor", janitorHandler(storage.NewLocal(t.TempDir(), ""), janitor.Config{}))
+
+	for _, path := range []string{
+		"/login",
+		"/processed_urls",
+		"/processed_urls?tag=" + payload,
+		"/user_downloads",
+		"/users/7",
+		"/statistics",
+		"/broadcasts",
+		"/proxies",
+		"/search?q=script",
+		"/janitor?run=1",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body := rec.Body.String()
+		// Without FTS5 there is no search page to check.
+		if rec.Code == http.StatusServiceUnavailable && strings.HasPrefix(path, "/search") {
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", path, rec.Code, body)
+			continue
+		}
+		if strings.Contains(body, payload) {
+			t.Errorf("%s: the payload is not escaped", path)
+		}
+		if !strings.Contains(body, "&lt;script&gt;") && !strings.Contains(body, "\\u003cscript\\u003e") &&
+			!strings.Contains(body, "&lt;<mark>script</mark>&gt;") && path != "/statistics" {
+			t.Errorf("%s: the payload is missing", path)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+)
+
+// userTimelineDays is the range of the download timeline of the user page.
+const userTimelineDays = 90
+
+// UserPage is the data of the page of one user. The timeline is fetched
+// from /api/v1/statistics/series, for From to To.
+type UserPage struct {
+	Profile   repository.UserProfile
+	Tiers     quota.Tiers
+	Tier      quota.Tier
+	Usage     quota.Usage
+	Exceeded  bool
+	Tags      []repository.TagCount
+	Downloads []repository.UserDownload
+	From      string
+	To        string
+}
+
+// userHandler shows the user with the Telegram ID in the path. Operators
+// POST an action to it: ban, with a reason, unban, or tier, with the tier
+// to move the user to.
+func userHandler(tiers quota.Tiers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			updateUser(w, r, tiers, userID)
+			return
+		}
+
+		profile, err := repo.UserProfile(userID)
+		if err == repository.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Printf("Error querying user %d: %v", userID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		page := UserPage{Profile: profile, Tiers: tiers, Tier: tiers.Get(profile.Tier)}
+		page.Usage.Downloads, page.Usage.Bytes, err = repo.UserUsage(userID, time.Now().Add(-quota.Window))
+		if err != nil {
+			logger.Printf("Error querying usage of user %d: %v", userID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Exceeded = page.Tier.Exceeded(page.Usage)
+
+		page.Tags, err = repo.TopTags(repository.StatsFilter{UserID: userID}, 10)
+		if err != nil {
+			logger.Printf("Error querying tags of user %d: %v", userID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Downloads, _, err = repo.ListDownloads(repository.ListFilter{UserID: userID}, repository.Page{Size: 10})
+		if err != nil {
+			logger.Printf("Error querying downloads of user %d: %v", userID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		to := time.Now().UTC()
+		page.From = to.AddDate(0, 0, 1-userTimelineDays).Format(dateLayout)
+		page.To = to.Format(dateLayout)
+
+		tmpl, err := loadTemplate(r, "user")
+		if err != nil {
+			logger.Printf("Error loading template: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.Execute(w, page); err != nil {
+			logger.Printf("Error executing template: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func updateUser(w http.ResponseWriter, r *http.Request, tiers quota.Tiers, userID int64) {
+	var err error
+	action := r.FormValue("action")
+	switch action {
+	case "ban":
+		err = repo.BanUser(userID, strings.TrimSpace(r.FormValue("reason")))
+	case "unban":
+		err = repo.UnbanUser(userID)
+	case "tier":
+		tier := r.FormValue("tier")
+		if !tiers.Valid(tier) {
+			http.Error(w, fmt.Sprintf("Unknown tier %q", tier), http.StatusBadRequest)
+			return
+		}
+		err = repo.SetUserTier(userID, tier)
+		action += " " + tier
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if err == repository.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error updating user %d: %v", userID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("%s changed user %d: %s", requestSession(r).Account.Username, userID, action)
+	http.Redirect(w, r, fmt.Sprintf("/users/%d", userID), http.StatusSeeOther)
+}
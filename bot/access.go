@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+)
+
+var selectUserAccessQuery = `SELECT tier, banned_at IS NOT NULL FROM users WHERE user_id = ?`
+
+// errQuotaExceeded is returned by enqueue when the user reached the limits
+// of their tier.
+var errQuotaExceeded = errors.New("download quota exceeded")
+
+// userAccess returns the tier of the user and whether they are banned. Users
+// the bot hasn't recorded yet are in the free tier.
+func userAccess(db *repository.DB, userID int64) (tier string, banned bool, err error) {
+	err = db.QueryRow(selectUserAccessQuery, userID).Scan(&tier, &banned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return quota.TierFree, false, nil
+	}
+	return tier, banned, err
+}
+
+// checkQuota returns errQuotaExceeded when the downloads of the user in the
+// last quota.Window, plus the queued ones not recorded yet, reach the limits
+// of their tier.
+func checkQuota(repo *repository.Repository, tiers quota.Tiers, userID int64, queued int) error {
+	tierName, _, err := userAccess(repo.DB(), userID)
+	if err != nil {
+		return err
+	}
+	tier := tiers.Get(tierName)
+	if tier.MaxDownloads == 0 && tier.MaxBytes == 0 {
+		return nil
+	}
+
+	var usage quota.Usage
+	usage.Downloads, usage.Bytes, err = repo.UserUsage(userID, time.Now().Add(-quota.Window))
+	if err != nil {
+		return err
+	}
+	usage.Downloads += queued
+	if tier.Exceeded(usage) {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// taskKey identifies a task by what its result echoes back.
+type taskKey struct {
+	chatID    int64
+	messageID int
+	mode      string
+}
+
+// inFlightTimeout is how long a task without a result counts against the
+// quota, in case the downloader lost it.
+const inFlightTimeout = time.Hour
+
+type reservation struct {
+	userID int64
+	at     time.Time
+}
+
+// inFlight holds the tasks published to the downloader that have no result
+// yet, so links sent in a burst count against the quota before their
+// downloads are recorded.
+type inFlight struct {
+	mu    sync.Mutex
+	tasks map[taskKey]reservation
+}
+
+// reserve records the task of the user and returns how many other tasks of
+// theirs are in flight.
+func (f *inFlight) reserve(key taskKey, userID int64) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tasks == nil {
+		f.tasks = make(map[taskKey]reservation)
+	}
+	now := time.Now()
+	queued := 0
+	for k, r := range f.tasks {
+		switch {
+		case now.Sub(r.at) > inFlightTimeout:
+			delete(f.tasks, k)
+		case r.userID == userID && k != key:
+			queued++
+		}
+	}
+	f.tasks[key] = reservation{userID: userID, at: now}
+	return queued
+}
+
+// release forgets the task, once its result arrived or it wasn't published.
+func (f *inFlight) release(key taskKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tasks, key)
+}
+
+// queueErrorKey is the message telling the user why enqueue failed.
+func queueErrorKey(err error) string {
+	if errors.Is(err, errQuotaExceeded) {
+		return "error.quota_exceeded"
+	}
+	return "error.queue_failed"
+}
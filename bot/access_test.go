@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+)
+
+func testRepository(t *testing.T) *repository.Repository {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	repo, err := repository.Open(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := migrations.Up(repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func recordDownloads(t *testing.T, repo *repository.Repository, userID int64, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := repo.RecordDownload(repository.Download{User: repository.User{UserID: userID}, URL: "https://www.instagram.com/p/x/", Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	repo := testRepository(t)
+	tiers := quota.Tiers{{Name: quota.TierFree, MaxDownloads: 2}, {Name: quota.TierPremium, MaxBytes: 100}}
+	recordDownloads(t, repo, 1, 1)
+	recordDownloads(t, repo, 2, 2)
+	recordDownloads(t, repo, 3, 5)
+	if err := repo.SetUserTier(3, quota.TierPremium); err != nil {
+		t.Fatal(err)
+	}
+
+	for userID, want := range map[int64]error{1: nil, 2: errQuotaExceeded, 3: nil, 4: nil} {
+		if err := checkQuota(repo, tiers, userID, 0); !errors.Is(err, want) {
+			t.Errorf("user %d: got %v, want %v", userID, err, want)
+		}
+	}
+	// Queued tasks count before their downloads are recorded.
+	if err := checkQuota(repo, tiers, 1, 1); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("user 1 with a queued task: got %v, want %v", err, errQuotaExceeded)
+	}
+
+	// Without the database, nobody gets past the quota.
+	repo.Close()
+	if err := checkQuota(repo, tiers, 1, 0); err == nil || errors.Is(err, errQuotaExceeded) {
+		t.Errorf("closed database: got %v, want a database error", err)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	var f inFlight
+	first, second := taskKey{1, 1, modeVideo}, taskKey{1, 1, modeAudio}
+	if n := f.reserve(first, 1); n != 0 {
+		t.Errorf("first task: %d queued, want 0", n)
+	}
+	if n := f.reserve(second, 1); n != 1 {
+		t.Errorf("second task: %d queued, want 1", n)
+	}
+	if n := f.reserve(taskKey{2, 1, modeVideo}, 2); n != 0 {
+		t.Errorf("other user: %d queued, want 0", n)
+	}
+	f.release(first)
+	if n := f.reserve(second, 1); n != 0 {
+		t.Errorf("after a result: %d queued, want 0", n)
+	}
+
+	f.tasks[first] = reservation{userID: 1, at: time.Now().Add(-2 * inFlightTimeout)}
+	if n := f.reserve(second, 1); n != 0 {
+		t.Errorf("lost task: %d queued, want 0", n)
+	}
+}
+
+// TestBroadcastSegments checks that no segment reaches users who blocked the
+// bot or are banned.
+func TestBroadcastSegments(t *testing.T) {
+	repo := testRepository(t)
+	// 1 downloaded recently, 2 is banned, 3 blocked the bot and 4 downloaded
+	// long ago.
+	for userID := int64(1); userID <= 3; userID++ {
+		recordDownloads(t, repo, userID, 1)
+	}
+	recordDownloads(t, repo, 4, 1)
+	db := repo.DB()
+	if _, err := db.Exec(`UPDATE downloads SET timestamp = '2020-01-01 00:00:00' WHERE user_id = 4`); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.BanUser(2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(insertBlockedUserQuery, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Broadcaster{db: db}
+	for id, test := range []struct {
+		segment string
+		want    string
+	}{
+		{"all", "1 4"},
+		{"active", "1"},
+		{"inactive", "4"},
+	} {
+		bc := Broadcast{ID: int64(id + 1), Kind: "text", Segment: test.segment, SegmentDays: 7}
+		if err := repo.CreateBroadcast(repository.Broadcast{Kind: bc.Kind, Segment: bc.Segment, SegmentDays: bc.SegmentDays}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.start(bc); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.Query(`SELECT user_id FROM broadcast_deliveries WHERE broadcast_id = ?`, bc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recipients []string
+		for rows.Next() {
+			var userID string
+			if err := rows.Scan(&userID); err != nil {
+				t.Fatal(err)
+			}
+			recipients = append(recipients, userID)
+		}
+		rows.Close()
+		sort.Strings(recipients)
+		if got := strings.Join(recipients, " "); got != test.want {
+			t.Errorf("segment %s: got recipients %s, want %s", test.segment, got, test.want)
+		}
+	}
+}
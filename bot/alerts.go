@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Alert is a message for the bot admins published by the other services.
+type Alert struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// adminChatIDs parses ADMIN_CHAT_IDS, a comma separated list of chat IDs.
+func adminChatIDs() []int64 {
+	var ids []int64
+	for _, field := range strings.Split(GetEnv("ADMIN_CHAT_IDS", ""), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid admin chat ID %q", field)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// forwardAlerts sends every alert from msgs to the admin chats.
+func forwardAlerts(bot *tgbotapi.BotAPI, msgs <-chan amqp091.Delivery, chatIDs []int64) {
+	for d := range msgs {
+		var alert Alert
+		err := json.Unmarshal(d.Body, &alert)
+		if err != nil {
+			log.Printf("Failed to unmarshal alert: %v", err)
+			continue
+		}
+		if len(chatIDs) == 0 {
+			log.Printf("Alert from %s (ADMIN_CHAT_IDS is not set): %s", alert.Source, alert.Message)
+			continue
+		}
+		for _, chatID := range chatIDs {
+			_, err := bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("⚠️ %s: %s", alert.Source, alert.Message)))
+			if err != nil {
+				log.Printf("Failed to send alert to %d: %v", chatID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+const (
+	broadcastStatusPending = "pending"
+
+	deliveryStatusSent    = "sent"
+	deliveryStatusFailed  = "failed"
+	deliveryStatusBlocked = "blocked"
+
+	// Maximum number of attempts for one recipient before the delivery is
+	// marked as failed. Only rate limit responses are retried.
+	maxDeliveryAttempts = 5
+)
+
+var (
+	nextBroadcastQuery = `SELECT id, kind, text, media, segment, segment_days, status FROM broadcasts
+		WHERE status IN ('pending', 'running') ORDER BY id LIMIT 1`
+	startBroadcastQuery    = `UPDATE broadcasts SET status = 'running', started_at = CURRENT_TIMESTAMP WHERE id = ?`
+	completeBroadcastQuery = `UPDATE broadcasts SET status = 'completed', finished_at = CURRENT_TIMESTAMP WHERE id = ?`
+	pendingDeliveriesQuery = `SELECT user_id, attempts FROM broadcast_deliveries
+		WHERE broadcast_id = ? AND status = 'pending' ORDER BY user_id LIMIT 100`
+	updateDeliveryQuery = `UPDATE broadcast_deliveries SET status = ?, attempts = ?, error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE broadcast_id = ? AND user_id = ?`
+	insertBlockedUserQuery = `INSERT INTO blocked_users (user_id) VALUES (?) ON CONFLICT DO NOTHING`
+
+	// Recipients are materialized once when a broadcast starts, so that a
+	// restarted bot continues with exactly the same audience. Users who
+	// blocked the bot or are banned are left out. The active and inactive
+	// segments take the start of the activity window.
+	segmentQueries = map[string]string{
+		"all": `INSERT INTO broadcast_deliveries (broadcast_id, user_id)
+			SELECT DISTINCT CAST(? AS BIGINT), u.user_id FROM users u
+			WHERE u.user_id NOT IN (SELECT user_id FROM blocked_users)
+			AND u.banned_at IS NULL
+			ON CONFLICT DO NOTHING`,
+		"active": `INSERT INTO broadcast_deliveries (broadcast_id, user_id)
+			SELECT DISTINCT CAST(? AS BIGINT), u.user_id FROM users u
+			WHERE u.user_id NOT IN (SELECT user_id FROM blocked_users)
+			AND u.banned_at IS NULL
+			AND u.user_id IN (SELECT user_id FROM downloads WHERE timestamp >= ?)
+			ON CONFLICT DO NOTHING`,
+		"inactive": `INSERT INTO broadcast_deliveries (broadcast_id, user_id)
+			SELECT DISTINCT CAST(? AS BIGINT), u.user_id FROM users u
+			WHERE u.user_id NOT IN (SELECT user_id FROM blocked_users)
+			AND u.banned_at IS NULL
+			AND u.user_id NOT IN (SELECT user_id FROM downloads WHERE timestamp >= ?)
+			ON CONFLICT DO NOTHING`,
+	}
+)
+
+type Broadcast struct {
+	ID          int64
+	Kind        string
+	Text        string
+	Media       string
+	Segment     string
+	SegmentDays int
+	Status      string
+}
+
+// Broadcaster sends admin-created broadcasts to users. Progress is stored per
+// recipient in broadcast_deliveries, so an interrupted broadcast is resumed on
+// the next poll after a restart.
+type Broadcaster struct {
+	bot     *tgbotapi.BotAPI
+	db      *repository.DB
+	limiter *time.Ticker
+}
+
+// NewBroadcaster creates a Broadcaster which sends at most rate messages per
+// second, staying below Telegram's global limit of 30 messages per second.
+func NewBroadcaster(bot *tgbotapi.BotAPI, db *repository.DB, rate int) *Broadcaster {
+	return &Broadcaster{
+		bot:     bot,
+		db:      db,
+		limiter: time.NewTicker(time.Second / time.Duration(rate)),
+	}
+}
+
+func (b *Broadcaster) Run(pollInterval time.Duration) {
+	log.Println("Starting broadcaster")
+	for {
+		for {
+			processed, err := b.processNext()
+			if err != nil {
+				log.Printf("Failed to process broadcast: %v", err)
+				break
+			}
+			if !processed {
+				break
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// processNext runs the oldest unfinished broadcast to completion. It reports
+// whether there was a broadcast to process.
+func (b *Broadcaster) processNext() (bool, error) {
+	var bc Broadcast
+	var text, media sql.NullString
+	err := b.db.QueryRow(nextBroadcastQuery).Scan(&bc.ID, &bc.Kind, &text, &media, &bc.Segment, &bc.SegmentDays, &bc.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	bc.Text = text.String
+	bc.Media = media.String
+
+	if bc.Status == broadcastStatusPending {
+		if err := b.start(bc); err != nil {
+			return false, err
+		}
+		log.Printf("Broadcast %d started for segment %q", bc.ID, bc.Segment)
+	} else {
+		log.Printf("Resuming broadcast %d", bc.ID)
+	}
+
+	for {
+		sent, err := b.deliverBatch(bc)
+		if err != nil {
+			return false, err
+		}
+		if sent == 0 {
+			break
+		}
+	}
+
+	_, err = b.db.Exec(completeBroadcastQuery, bc.ID)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Broadcast %d completed", bc.ID)
+	return true, nil
+}
+
+func (b *Broadcaster) start(bc Broadcast) error {
+	query, ok := segmentQueries[bc.Segment]
+	if !ok {
+		log.Printf("Unknown broadcast segment %q, falling back to all users", bc.Segment)
+		query = segmentQueries["all"]
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{bc.ID}
+	if bc.Segment != "all" {
+		since := time.Now().AddDate(0, 0, -bc.SegmentDays).UTC().Format(time.DateTime)
+		args = append(args, since)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(startBroadcastQuery, bc.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type pendingDelivery struct {
+	UserID   int64
+	Attempts int
+}
+
+// deliverBatch sends the broadcast to the next batch of pending recipients and
+// returns how many recipients it handled.
+func (b *Broadcaster) deliverBatch(bc Broadcast) (int, error) {
+	rows, err := b.db.Query(pendingDeliveriesQuery, bc.ID)
+	if err != nil {
+		return 0, err
+	}
+	var batch []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.UserID, &d.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range batch {
+		status, attempts, sendErr := b.deliver(bc, d)
+		var errText interface{}
+		if sendErr != nil {
+			errText = sendErr.Error()
+		}
+		_, err := b.db.Exec(updateDeliveryQuery, status, attempts, errText, bc.ID, d.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if status == deliveryStatusBlocked {
+			_, err = b.db.Exec(insertBlockedUserQuery, d.UserID)
+			if err != nil {
+				log.Printf("Failed to mark user %d as blocked: %v", d.UserID, err)
+			}
+		}
+	}
+
+	return len(batch), nil
+}
+
+// deliver sends the broadcast to one user, waiting out 429 responses, and
+// returns the final delivery status.
+func (b *Broadcaster) deliver(bc Broadcast, d pendingDelivery) (string, int, error) {
+	attempts := d.Attempts
+	for {
+		<-b.limiter.C
+		attempts++
+		_, err := b.bot.Send(broadcastMessage(bc, d.UserID))
+		if err == nil {
+			return deliveryStatusSent, attempts, nil
+		}
+
+		var tgErr tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 && attempts < maxDeliveryAttempts {
+			log.Printf("Broadcast %d rate limited, retrying after %ds", bc.ID, tgErr.RetryAfter)
+			time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+			continue
+		}
+		if isBlockedError(err) {
+			return deliveryStatusBlocked, attempts, err
+		}
+		log.Printf("Failed to deliver broadcast %d to %d: %v", bc.ID, d.UserID, err)
+		return deliveryStatusFailed, attempts, err
+	}
+}
+
+// broadcastMessage builds the message for a private chat, whose chat ID is the
+// Telegram user ID. Media is a URL or a Telegram file_id.
+func broadcastMessage(bc Broadcast, chatID int64) tgbotapi.Chattable {
+	switch bc.Kind {
+	case "photo":
+		msg := tgbotapi.NewPhotoShare(chatID, bc.Media)
+		msg.Caption = bc.Text
+		return msg
+	case "video":
+		msg := tgbotapi.NewVideoShare(chatID, bc.Media)
+		msg.Caption = bc.Text
+		return msg
+	default:
+		return tgbotapi.NewMessage(chatID, bc.Text)
+	}
+}
+
+// isBlockedError reports whether Telegram refused the message because the
+// user can no longer be reached by the bot.
+func isBlockedError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"bot was blocked", "user is deactivated", "chat not found", "bot can't initiate conversation"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rabbitmq/amqp091-go"
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+)
+
+const (
+	modeVideo = "video"
+	modeAudio = "audio"
+)
+
+// Handler reacts to Telegram updates and to download results coming back
+// from the downloader.
+type Handler struct {
+	bot              *tgbotapi.BotAPI
+	repo             *repository.Repository
+	db               *repository.DB
+	ch               *amqp091.Channel
+	downloadQueue    string
+	store            storage.Storage
+	tiers            quota.Tiers
+	maxSubscriptions int
+	inFlight         inFlight
+}
+
+func (h *Handler) HandleUpdate(update tgbotapi.Update) {
+	switch {
+	case update.CallbackQuery != nil:
+		h.handleCallback(update.CallbackQuery)
+	case update.Message != nil:
+		h.handleMessage(update.Message)
+	}
+}
+
+func (h *Handler) handleMessage(message *tgbotapi.Message) {
+	if message.From == nil {
+		return
+	}
+	lang := userLanguage(h.db, int64(message.From.ID), message.From.LanguageCode)
+	_, banned, err := userAccess(h.db, int64(message.From.ID))
+	if err != nil {
+		log.Printf("Failed to read access of user %d: %v", message.From.ID, err)
+		h.reply(message, T(lang, "error.queue_failed"))
+		return
+	}
+	if banned {
+		h.reply(message, T(lang, "error.banned"))
+		return
+	}
+
+	if message.IsCommand() {
+		h.handleCommand(message, lang)
+		return
+	}
+
+	link, ok := extractLink(message.Text)
+	if !ok {
+		h.reply(message, T(lang, "error.invalid_link"))
+		return
+	}
+	p, ok := platform.Match(link)
+	if !ok {
+		h.reply(message, T(lang, "error.unsupported", platformList()))
+		return
+	}
+
+	task := DownloadTask{
+		URL:       link,
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+		User:      userInfo(message.From),
+		Language:  lang,
+		Mode:      modeVideo,
+		Platform:  p.Name,
+	}
+	if isStoryLink(link) {
+		task.Mode = modeStories
+	}
+	if err := h.enqueue(task); err != nil {
+		log.Printf("Failed to publish task: %v", err)
+		h.reply(message, T(lang, queueErrorKey(err)))
+		return
+	}
+	log.Println("Task published")
+
+	if task.Mode == modeStories {
+		h.reply(message, T(lang, "queued.stories"))
+		return
+	}
+
+	// The acknowledgement replies to the link, so the audio button can find
+	// the link again from the callback.
+	msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "queued"))
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(T(lang, "button.audio"), "audio"),
+	))
+	h.send(msg)
+}
+
+// handleAudioCommand queues an audio-only download for /audio <url>.
+func (h *Handler) handleAudioCommand(message *tgbotapi.Message, lang string) {
+	link, ok := extractLink(message.CommandArguments())
+	if !ok {
+		h.reply(message, T(lang, "error.invalid_link"))
+		return
+	}
+	p, ok := platform.Match(link)
+	if !ok {
+		h.reply(message, T(lang, "error.unsupported", platformList()))
+		return
+	}
+
+	task := DownloadTask{
+		URL:       link,
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+		User:      userInfo(message.From),
+		Language:  lang,
+		Mode:      modeAudio,
+		Platform:  p.Name,
+	}
+	if err := h.enqueue(task); err != nil {
+		log.Printf("Failed to publish task: %v", err)
+		h.reply(message, T(lang, queueErrorKey(err)))
+		return
+	}
+	h.reply(message, T(lang, "queued.audio"))
+}
+
+func (h *Handler) handleCommand(message *tgbotapi.Message, lang string) {
+	switch message.Command() {
+	case "start":
+		h.reply(message, T(lang, "start"))
+	case "help":
+		h.reply(message, T(lang, "help"))
+	case "platforms":
+		h.reply(message, T(lang, "platforms.list", platformList()))
+	case "audio":
+		h.handleAudioCommand(message, lang)
+	case "stories":
+		h.handleStoriesCommand(message, lang)
+	case "follow":
+		h.handleFollowCommand(message, lang)
+	case "unfollow":
+		h.handleUnfollowCommand(message, lang)
+	case "following":
+		h.handleFollowingCommand(message, lang)
+	case "language":
+		h.handleLanguageCommand(message, lang)
+	default:
+		h.reply(message, T(lang, "help"))
+	}
+}
+
+// handleLanguageCommand switches the language given as an argument, or offers
+// the available languages as inline buttons.
+func (h *Handler) handleLanguageCommand(message *tgbotapi.Message, lang string) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, code := range supportedLanguages() {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(T(code, "language.name"), "lang:"+code))
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, T(lang, "language.choose"))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+		h.send(msg)
+		return
+	}
+
+	if _, ok := catalog[arg]; !ok {
+		h.reply(message, T(lang, "language.unknown", strings.Join(supportedLanguages(), ", ")))
+		return
+	}
+	if err := setUserLanguage(h.db, int64(message.From.ID), arg); err != nil {
+		log.Printf("Failed to save language: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+	h.reply(message, T(arg, "language.changed"))
+}
+
+func (h *Handler) handleCallback(query *tgbotapi.CallbackQuery) {
+	_, err := h.bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+	if err != nil {
+		log.Printf("Failed to answer callback query: %v", err)
+	}
+	if query.Message == nil {
+		return
+	}
+	_, banned, err := userAccess(h.db, int64(query.From.ID))
+	if err != nil {
+		log.Printf("Failed to read access of user %d: %v", query.From.ID, err)
+		return
+	}
+	if banned {
+		return
+	}
+
+	if code, ok := strings.CutPrefix(query.Data, "lang:"); ok {
+		if _, ok := catalog[code]; !ok {
+			return
+		}
+		if err := setUserLanguage(h.db, int64(query.From.ID), code); err != nil {
+			log.Printf("Failed to save language: %v", err)
+			h.send(tgbotapi.NewMessage(query.Message.Chat.ID, T(code, "error.settings_failed")))
+			return
+		}
+		h.send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, T(code, "language.changed")))
+		return
+	}
+
+	if query.Data == "audio" {
+		h.handleAudioButton(query)
+	}
+}
+
+// handleAudioButton queues an audio-only download of the link the pressed
+// acknowledgement replied to.
+func (h *Handler) handleAudioButton(query *tgbotapi.CallbackQuery) {
+	lang := userLanguage(h.db, int64(query.From.ID), query.From.LanguageCode)
+	original := query.Message.ReplyToMessage
+	if original == nil {
+		return
+	}
+	link, ok := extractLink(original.Text)
+	if !ok {
+		return
+	}
+	p, ok := platform.Match(link)
+	if !ok {
+		return
+	}
+
+	task := DownloadTask{
+		URL:       link,
+		ChatID:    query.Message.Chat.ID,
+		MessageID: original.MessageID,
+		User:      userInfo(query.From),
+		Language:  lang,
+		Mode:      modeAudio,
+		Platform:  p.Name,
+	}
+	if err := h.enqueue(task); err != nil {
+		log.Printf("Failed to publish task: %v", err)
+		h.send(tgbotapi.NewMessage(query.Message.Chat.ID, T(lang, queueErrorKey(err))))
+		return
+	}
+	// Editing the text drops the button, so the audio is queued only once.
+	h.send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, T(lang, "queued.audio")))
+}
+
+// HandleResult delivers a completed download, or the reason it failed, to the
+// chat that requested it.
+func (h *Handler) HandleResult(result DownloadResult) {
+	h.inFlight.release(taskKey{result.ChatID, result.MessageID, result.Mode})
+	lang := normalizeLanguage(result.Language)
+
+	if result.Error != "" {
+		log.Printf("Download of %s failed: %s", result.URL, result.Error)
+		key := "error.download_failed"
+		switch {
+		case result.ErrorClass == string(platform.ErrorPrivate):
+			key = "error.private"
+		case result.ErrorClass == string(platform.ErrorNotFound):
+			key = "error.not_found"
+		case result.ErrorClass == string(platform.ErrorRateLimited):
+			key = "error.rate_limited"
+		case result.Mode == modeAudio:
+			key = "error.audio_failed"
+		case result.Mode == modeStories:
+			key = "error.stories_failed"
+		}
+		msg := tgbotapi.NewMessage(result.ChatID, T(lang, key))
+		msg.ReplyToMessageID = result.MessageID
+		h.send(msg)
+		return
+	}
+
+	switch result.Mode {
+	case modeAudio:
+		h.sendAudio(result, lang)
+		return
+	case modeStories:
+		h.sendStories(result, lang)
+		return
+	}
+
+	h.sendVideo(result, lang)
+}
+
+func (h *Handler) sendVideo(result DownloadResult, lang string) {
+	params := map[string]string{
+		"chat_id":            strconv.FormatInt(result.ChatID, 10),
+		"caption":            Caption(lang, resultCaption(result), result.URL),
+		"supports_streaming": "true",
+	}
+	if result.MessageID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(result.MessageID)
+	}
+	if result.Duration > 0 {
+		params["duration"] = strconv.Itoa(result.Duration)
+	}
+
+	_, err := uploadFiles(h.bot, h.store, "sendVideo", params, map[string]string{
+		"video":     result.FilePath,
+		"thumbnail": result.Thumbnail,
+	})
+	if err != nil {
+		log.Printf("Failed to send video: %v", err)
+		reply := tgbotapi.NewMessage(result.ChatID, T(lang, "error.upload_failed"))
+		reply.ReplyToMessageID = result.MessageID
+		h.send(reply)
+	}
+
+	h.deleteThumbnail(result.Thumbnail)
+}
+
+func (h *Handler) sendAudio(result DownloadResult, lang string) {
+	params := map[string]string{
+		"chat_id":   strconv.FormatInt(result.ChatID, 10),
+		"caption":   Caption(lang, "", result.URL),
+		"title":     result.Title,
+		"performer": result.Performer,
+	}
+	if result.MessageID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(result.MessageID)
+	}
+	if result.Duration > 0 {
+		params["duration"] = strconv.Itoa(result.Duration)
+	}
+
+	_, err := uploadFiles(h.bot, h.store, "sendAudio", params, map[string]string{
+		"audio":     result.FilePath,
+		"thumbnail": result.Thumbnail,
+	})
+	if err != nil {
+		log.Printf("Failed to send audio: %v", err)
+		reply := tgbotapi.NewMessage(result.ChatID, T(lang, "error.upload_failed"))
+		reply.ReplyToMessageID = result.MessageID
+		h.send(reply)
+	}
+
+	h.deleteThumbnail(result.Thumbnail)
+}
+
+// deleteThumbnail removes the Telegram thumbnail of a result once it was
+// uploaded. The media itself stays in the downloader's media store.
+func (h *Handler) deleteThumbnail(key string) {
+	if key == "" {
+		return
+	}
+	if err := h.store.Delete(context.Background(), key); err != nil {
+		log.Printf("Failed to delete thumbnail %s: %v", key, err)
+	}
+}
+
+// enqueue publishes the task for the downloader, unless the user is out of
+// quota. The task counts against the quota until its result arrives.
+func (h *Handler) enqueue(task DownloadTask) error {
+	key := taskKey{task.ChatID, task.MessageID, task.Mode}
+	queued := h.inFlight.reserve(key, task.User.UserID)
+	if err := checkQuota(h.repo, h.tiers, task.User.UserID, queued); err != nil {
+		h.inFlight.release(key)
+		return err
+	}
+	body, err := json.Marshal(task)
+	if err != nil {
+		h.inFlight.release(key)
+		return err
+	}
+
+	err = h.ch.Publish(
+		"",
+		h.downloadQueue,
+		false,
+		false,
+		amqp091.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
+	if err != nil {
+		h.inFlight.release(key)
+	}
+	return err
+}
+
+func (h *Handler) reply(message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	h.send(msg)
+}
+
+func (h *Handler) send(c tgbotapi.Chattable) {
+	_, err := h.bot.Send(c)
+	if err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+func userInfo(from *tgbotapi.User) UserInfo {
+	return UserInfo{
+		UserID:    int64(from.ID),
+		UserName:  from.UserName,
+		FirstName: from.FirstName,
+		LastName:  from.LastName,
+	}
+}
+
+// platformList renders the supported platforms one per line.
+func platformList() string {
+	return "• " + strings.Join(platform.Titles(), "\n• ")
+}
+
+// resultCaption renders the caption body with the template of the platform
+// the video came from.
+func resultCaption(result DownloadResult) string {
+	p, ok := platform.ByName(result.Platform)
+	if !ok {
+		return result.Description
+	}
+	return p.Caption(platform.CaptionData{
+		Title:       result.Title,
+		Description: result.Description,
+		Uploader:    result.Uploader,
+		URL:         result.URL,
+	})
+}
+
+// extractLink returns the first http(s) URL found in the message text.
+func extractLink(text string) (string, bool) {
+	for _, field := range strings.Fields(text) {
+		u, err := url.Parse(field)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return field, true
+		}
+	}
+	return "", false
+}
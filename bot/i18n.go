@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+const defaultLanguage = "en"
+
+// Maximum caption length accepted by Telegram for media messages.
+const maxCaptionLength = 1024
+
+// catalog holds every user-facing bot reply. Each language must define
+// exactly the same keys as the default language; checkCatalog enforces this
+// at startup.
+var catalog = map[string]map[string]string{
+	"en": {
+		"language.name":          "English",
+		"start":                  "Hi! Send me a link to a post, reel or short video and I will send the video back. /platforms shows the supported sites.",
+		"help":                   "Send me a link to a post, reel or short video and I will send the video back.\n\n/platforms — supported sites\n/audio <link> — send only the sound\n/stories <username> — send the current stories\n/follow <username> — receive new posts of an account\n/unfollow <username> — stop receiving them\n/following — list followed accounts\n/language — change the language",
+		"queued":                 "Got it, downloading…",
+		"queued.audio":           "Got it, extracting the sound…",
+		"queued.stories":         "Got it, fetching the stories…",
+		"stories.none":           "There are no new stories.",
+		"caption.stories":        "Stories of @%s",
+		"follow.added":           "You will now receive new posts of @%s.",
+		"follow.exists":          "You already follow @%s.",
+		"follow.removed":         "You no longer follow @%s.",
+		"follow.not_found":       "You don't follow @%s.",
+		"follow.list":            "You follow:\n%s",
+		"follow.none":            "You don't follow anyone yet. Use /follow <username>.",
+		"follow.limit":           "You can follow at most %d accounts.",
+		"button.audio":           "🎵 Audio only",
+		"language.choose":        "Choose your language:",
+		"language.changed":       "Language set to English.",
+		"language.unknown":       "Unknown language. Available: %s",
+		"caption.video":          "%s\n\nSource: %s",
+		"error.invalid_link":     "This doesn't look like a link. Please send me a URL of a post or reel.",
+		"platforms.list":         "I can download from:\n%s",
+		"error.unsupported":      "Sorry, I can't download from this site yet. Supported sites:\n%s",
+		"error.private":          "This video is private, so I can't download it.",
+		"error.not_found":        "This video doesn't exist or was removed.",
+		"error.rate_limited":     "The site is limiting requests right now. Please try again later.",
+		"error.queue_failed":     "Sorry, I couldn't accept your link right now. Please try again later.",
+		"error.quota_exceeded":   "You have reached your daily download limit. Please try again tomorrow.",
+		"error.banned":           "Sorry, you can no longer use this bot.",
+		"error.download_failed":  "Sorry, I couldn't download this video.",
+		"error.audio_failed":     "Sorry, I couldn't extract the sound from this video.",
+		"error.stories_failed":   "Sorry, I couldn't fetch these stories.",
+		"error.invalid_username": "Please send an Instagram username, for example @instagram.",
+		"error.upload_failed":    "Sorry, I downloaded the video but couldn't send it to you.",
+		"error.settings_failed":  "Sorry, I couldn't save your settings. Please try again later.",
+	},
+	"ru": {
+		"language.name":          "Русский",
+		"start":                  "Привет! Пришлите мне ссылку на пост, рилс или короткое видео, и я пришлю вам видео. /platforms покажет поддерживаемые сайты.",
+		"help":                   "Пришлите мне ссылку на пост, рилс или короткое видео, и я пришлю вам видео.\n\n/platforms — поддерживаемые сайты\n/audio <ссылка> — прислать только звук\n/stories <имя> — прислать текущие истории\n/follow <имя> — получать новые посты аккаунта\n/unfollow <имя> — перестать их получать\n/following — список отслеживаемых аккаунтов\n/language — сменить язык",
+		"queued":                 "Принято, скачиваю…",
+		"queued.audio":           "Принято, извлекаю звук…",
+		"queued.stories":         "Принято, загружаю истории…",
+		"stories.none":           "Новых историй нет.",
+		"caption.stories":        "Истории @%s",
+		"follow.added":           "Теперь вы будете получать новые посты @%s.",
+		"follow.exists":          "Вы уже подписаны на @%s.",
+		"follow.removed":         "Вы больше не подписаны на @%s.",
+		"follow.not_found":       "Вы не подписаны на @%s.",
+		"follow.list":            "Ваши подписки:\n%s",
+		"follow.none":            "У вас пока нет подписок. Используйте /follow <имя>.",
+		"follow.limit":           "Можно подписаться не более чем на %d аккаунтов.",
+		"button.audio":           "🎵 Только звук",
+		"language.choose":        "Выберите язык:",
+		"language.changed":       "Язык изменён на русский.",
+		"language.unknown":       "Неизвестный язык. Доступны: %s",
+		"caption.video":          "%s\n\nИсточник: %s",
+		"error.invalid_link":     "Это не похоже на ссылку. Пришлите, пожалуйста, URL поста или рилса.",
+		"platforms.list":         "Я умею скачивать с:\n%s",
+		"error.unsupported":      "Этот сайт пока не поддерживается. Поддерживаются:\n%s",
+		"error.private":          "Это видео приватное, скачать его нельзя.",
+		"error.not_found":        "Это видео не существует или было удалено.",
+		"error.rate_limited":     "Сайт сейчас ограничивает запросы. Пожалуйста, попробуйте позже.",
+		"error.queue_failed":     "Не удалось принять ссылку. Пожалуйста, попробуйте позже.",
+		"error.quota_exceeded":   "Вы достигли дневного лимита загрузок. Пожалуйста, попробуйте завтра.",
+		"error.banned":           "К сожалению, вы больше не можете пользоваться этим ботом.",
+		"error.download_failed":  "К сожалению, не удалось скачать это видео.",
+		"error.audio_failed":     "К сожалению, не удалось извлечь звук из этого видео.",
+		"error.stories_failed":   "К сожалению, не удалось загрузить эти истории.",
+		"error.invalid_username": "Укажите имя пользователя Instagram, например @instagram.",
+		"error.upload_failed":    "Видео скачано, но отправить его не удалось.",
+		"error.settings_failed":  "Не удалось сохранить настройки. Пожалуйста, попробуйте позже.",
+	},
+}
+
+var (
+	selectLanguageQuery = `SELECT language FROM user_settings WHERE user_id = ?`
+	upsertLanguageQuery = `INSERT INTO user_settings (user_id, language) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET language = excluded.language`
+)
+
+// T renders the message with the given key in lang, falling back to the
+// default language.
+func T(lang, key string, args ...interface{}) string {
+	messages, ok := catalog[lang]
+	if !ok {
+		messages = catalog[defaultLanguage]
+	}
+	message, ok := messages[key]
+	if !ok {
+		message = catalog[defaultLanguage][key]
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Caption renders a media caption, shortening the description so that the
+// whole caption fits into Telegram's limit.
+func Caption(lang, description, url string) string {
+	caption := strings.TrimSpace(T(lang, "caption.video", description, url))
+	overflow := len([]rune(caption)) - maxCaptionLength
+	if overflow <= 0 {
+		return caption
+	}
+	runes := []rune(description)
+	keep := len(runes) - overflow - 1
+	if keep < 0 {
+		keep = 0
+	}
+	return strings.TrimSpace(T(lang, "caption.video", string(runes[:keep])+"…", url))
+}
+
+// normalizeLanguage maps a Telegram language_code such as "ru" or "en-US" to
+// a supported catalog language.
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := catalog[code]; ok {
+		return code
+	}
+	return defaultLanguage
+}
+
+// supportedLanguages returns the catalog languages in a stable order.
+func supportedLanguages() []string {
+	var languages []string
+	for lang := range catalog {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// userLanguage returns the language chosen by the user in settings, or the
+// one detected from their Telegram client.
+func userLanguage(db *repository.DB, userID int64, languageCode string) string {
+	var lang string
+	err := db.QueryRow(selectLanguageQuery, userID).Scan(&lang)
+	if err == nil {
+		return normalizeLanguage(lang)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to read language of user %d: %v", userID, err)
+	}
+	return normalizeLanguage(languageCode)
+}
+
+func setUserLanguage(db *repository.DB, userID int64, lang string) error {
+	_, err := db.Exec(upsertLanguageQuery, userID, lang)
+	return err
+}
+
+// checkCatalog verifies that every language defines exactly the keys of the
+// default language, so a missing translation is caught before the bot starts.
+func checkCatalog() error {
+	var problems []string
+	reference := catalog[defaultLanguage]
+	for _, lang := range supportedLanguages() {
+		messages := catalog[lang]
+		for key := range reference {
+			if _, ok := messages[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %q", lang, key))
+			}
+		}
+		for key := range messages {
+			if _, ok := reference[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: unexpected %q", lang, key))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("incomplete message catalog: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
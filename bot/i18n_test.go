@@ -0,0 +1,118 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// usedKeys returns the message keys the bot's sources look up: literal keys
+// passed to T, literals assigned to a variable named key and literals
+// returned by functions whose name ends in Key.
+func usedKeys(t *testing.T) map[string][]string {
+	t.Helper()
+	paths, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string][]string{}
+	fset := token.NewFileSet()
+	add := func(expr ast.Expr) {
+		lit, ok := expr.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return
+		}
+		key, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[key] = append(keys[key], fset.Position(lit.Pos()).String())
+	}
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if fn, ok := n.Fun.(*ast.Ident); ok && fn.Name == "T" && len(n.Args) >= 2 {
+					add(n.Args[1])
+				}
+			case *ast.AssignStmt:
+				for i, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && id.Name == "key" && i < len(n.Rhs) {
+						add(n.Rhs[i])
+					}
+				}
+			case *ast.FuncDecl:
+				if !strings.HasSuffix(n.Name.Name, "Key") || n.Body == nil {
+					return true
+				}
+				ast.Inspect(n.Body, func(n ast.Node) bool {
+					if ret, ok := n.(*ast.ReturnStmt); ok {
+						for _, result := range ret.Results {
+							add(result)
+						}
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+	return keys
+}
+
+func TestCatalogHasUsedKeys(t *testing.T) {
+	keys := usedKeys(t)
+	if len(keys) == 0 {
+		t.Fatal("found no message keys in the bot sources")
+	}
+	var used []string
+	for key := range keys {
+		used = append(used, key)
+	}
+	sort.Strings(used)
+
+	for _, lang := range []string{"en", "ru"} {
+		messages, ok := catalog[lang]
+		if !ok {
+			t.Errorf("catalog has no %q language", lang)
+			continue
+		}
+		for _, key := range used {
+			if _, ok := messages[key]; !ok {
+				t.Errorf("%s: missing %q, used at %s", lang, key, strings.Join(keys[key], ", "))
+			}
+		}
+	}
+}
+
+func TestCheckCatalog(t *testing.T) {
+	if err := checkCatalog(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaptionIsTrimmed(t *testing.T) {
+	for _, description := range []string{"\n  short  \n", "\n" + strings.Repeat("a", 2*maxCaptionLength)} {
+		caption := Caption("en", description, "https://example.com/p/1")
+		if caption != strings.TrimSpace(caption) {
+			t.Errorf("caption of %d runes is not trimmed: %q", len(description), caption[:10])
+		}
+		if n := len([]rune(caption)); n > maxCaptionLength {
+			t.Errorf("caption has %d runes, limit is %d", n, maxCaptionLength)
+		}
+	}
+}
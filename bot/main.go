@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/rabbitmq/amqp091-go"
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
 )
 
 type UserInfo struct {
@@ -21,20 +28,65 @@ type DownloadTask struct {
 	ChatID    int64    `json:"chat_id"`
 	MessageID int      `json:"message_id"`
 	User      UserInfo `json:"user"`
+	Language  string   `json:"language"`
+	Mode      string   `json:"mode"`
+	Platform  string   `json:"platform"`
 }
 
 type DownloadResult struct {
-	URL          string `json:"url"`
-	FilePath     string `json:"file_path"`
-	Size         int64  `json:"size"`
-	PreviewImage string `json:"preview_image"`
-	Tags         string `json:"tags"`
-	Description  string `json:"description"`
-	ChatID       int64  `json:"chat_id"`
-	MessageID    int    `json:"message_id"`
+	URL          string      `json:"url"`
+	FilePath     string      `json:"file_path"`
+	Size         int64       `json:"size"`
+	PreviewImage string      `json:"preview_image"`
+	Tags         string      `json:"tags"`
+	Description  string      `json:"description"`
+	ChatID       int64       `json:"chat_id"`
+	MessageID    int         `json:"message_id"`
+	Language     string      `json:"language"`
+	Mode         string      `json:"mode"`
+	Title        string      `json:"title"`
+	Performer    string      `json:"performer"`
+	Duration     int         `json:"duration"`
+	Thumbnail    string      `json:"thumbnail"`
+	Items        []MediaItem `json:"items,omitempty"`
+	Platform     string      `json:"platform"`
+	Uploader     string      `json:"uploader"`
+	Error        string      `json:"error,omitempty"`
+	ErrorClass   string      `json:"error_class,omitempty"`
+}
+
+var databaseFile = "/app/data/videos.db"
+
+func GetEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
+
+func initDB() (*repository.Repository, error) {
+	log.Println("Initializing database")
+	repo, err := repository.Open(databaseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The downloader owns the schema and migrates it at startup.
+	err = migrations.Wait(context.Background(), repo.DB())
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+
+	return repo, nil
 }
 
 func main() {
+	if err := checkCatalog(); err != nil {
+		log.Fatal(err)
+	}
+
 	bot, err := tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_BOT_TOKEN"))
 	if err != nil {
 		log.Panic(err)
@@ -47,6 +99,37 @@ func main() {
 
 	updates, _ := bot.GetUpdatesChan(u)
 
+	repo, err := initDB()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer repo.Close()
+	db := repo.DB()
+
+	rate, err := strconv.Atoi(GetEnv("BROADCAST_RATE", "25"))
+	if err != nil || rate <= 0 {
+		log.Fatalf("Invalid BROADCAST_RATE: %q", GetEnv("BROADCAST_RATE", "25"))
+	}
+	pollInterval, err := time.ParseDuration(GetEnv("BROADCAST_POLL_INTERVAL", "30s"))
+	if err != nil {
+		log.Fatalf("Invalid BROADCAST_POLL_INTERVAL: %v", err)
+	}
+	broadcaster := NewBroadcaster(bot, db, rate)
+	go broadcaster.Run(pollInterval)
+
+	st, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	tiers, err := quota.FromEnv(GetEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxSubscriptions, err := strconv.Atoi(GetEnv("MAX_SUBSCRIPTIONS", "20"))
+	if err != nil || maxSubscriptions <= 0 {
+		log.Fatalf("Invalid MAX_SUBSCRIPTIONS: %q", GetEnv("MAX_SUBSCRIPTIONS", "20"))
+	}
+
 	conn, err := amqp091.Dial(os.Getenv("RABBITMQ_URL"))
 	if err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
@@ -96,6 +179,43 @@ func main() {
 		log.Fatal(err)
 	}
 
+	alertQueue, err := ch.QueueDeclare(
+		"admin_alerts",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	alerts, err := ch.Consume(
+		alertQueue.Name,
+		"",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go forwardAlerts(bot, alerts, adminChatIDs())
+
+	handler := &Handler{
+		bot:              bot,
+		repo:             repo,
+		db:               db,
+		ch:               ch,
+		downloadQueue:    downloadQueue.Name,
+		store:            st,
+		tiers:            tiers,
+		maxSubscriptions: maxSubscriptions,
+	}
+
 	go func() {
 		for d := range msgs {
 			var result DownloadResult
@@ -104,66 +224,11 @@ func main() {
 				log.Printf("Failed to unmarshal result: %v", err)
 				continue
 			}
-
-			msg := tgbotapi.NewVideoUpload(result.ChatID, result.FilePath)
-			msg.Caption = result.Description
-			msg.ReplyToMessageID = result.MessageID
-
-			_, err = bot.Send(msg)
-			if err != nil {
-				log.Printf("Failed to send video: %v", err)
-			}
-
-			err = os.Remove(result.FilePath)
-			if err != nil {
-				log.Printf("Failed to delete video file: %v", err)
-			}
+			handler.HandleResult(result)
 		}
 	}()
 
 	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-
-		link := update.Message.Text
-		chatID := update.Message.Chat.ID
-		messageID := update.Message.MessageID
-
-		user := UserInfo{
-			UserID:    int64(update.Message.From.ID),
-			UserName:  update.Message.From.UserName,
-			FirstName: update.Message.From.FirstName,
-			LastName:  update.Message.From.LastName,
-		}
-
-		task := DownloadTask{
-			URL:       link,
-			ChatID:    chatID,
-			MessageID: messageID,
-			User:      user,
-		}
-
-		body, err := json.Marshal(task)
-		if err != nil {
-			log.Printf("Failed to marshal task: %v", err)
-			continue
-		}
-
-		err = ch.Publish(
-			"",
-			downloadQueue.Name,
-			false,
-			false,
-			amqp091.Publishing{
-				ContentType: "application/json",
-				Body:        body,
-			})
-		if err != nil {
-			log.Printf("Failed to publish task: %v", err)
-			continue
-		} else {
-			log.Println("Task published")
-		}
+		handler.HandleUpdate(update)
 	}
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const modeStories = "stories"
+
+// Telegram accepts between 2 and 10 items in one media group.
+const maxMediaGroupSize = 10
+
+var instagramUsername = regexp.MustCompile(`^[A-Za-z0-9._]{1,30}$`)
+
+// MediaItem is one photo or video of a story reel or highlight. Path is its
+// storage key.
+type MediaItem struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	TakenAt  int64  `json:"taken_at"`
+	Size     int64  `json:"size"`
+	StoryURL string `json:"story_url"`
+}
+
+// isStoryLink reports whether link points to an Instagram story or highlight.
+func isStoryLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return host == "instagram.com" && strings.HasPrefix(u.Path, "/stories/")
+}
+
+// handleStoriesCommand queues the current stories of /stories <username>.
+func (h *Handler) handleStoriesCommand(message *tgbotapi.Message, lang string) {
+	username, ok := parseUsername(message.CommandArguments())
+	if !ok {
+		h.reply(message, T(lang, "error.invalid_username"))
+		return
+	}
+
+	task := DownloadTask{
+		URL:       fmt.Sprintf("https://www.instagram.com/stories/%s/", username),
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+		User:      userInfo(message.From),
+		Language:  lang,
+		Mode:      modeStories,
+		Platform:  "instagram",
+	}
+	if err := h.enqueue(task); err != nil {
+		log.Printf("Failed to publish task: %v", err)
+		h.reply(message, T(lang, queueErrorKey(err)))
+		return
+	}
+	h.reply(message, T(lang, "queued.stories"))
+}
+
+// sendStories delivers downloaded story items as media groups. The items
+// live in the downloader's media store and are kept.
+func (h *Handler) sendStories(result DownloadResult, lang string) {
+	if len(result.Items) == 0 {
+		msg := tgbotapi.NewMessage(result.ChatID, T(lang, "stories.none"))
+		msg.ReplyToMessageID = result.MessageID
+		h.send(msg)
+		return
+	}
+
+	for start := 0; start < len(result.Items); start += maxMediaGroupSize {
+		end := start + maxMediaGroupSize
+		if end > len(result.Items) {
+			end = len(result.Items)
+		}
+		caption := ""
+		if start == 0 {
+			caption = Caption(lang, T(lang, "caption.stories", result.Performer), result.URL)
+		}
+		if err := h.sendMediaGroup(result, result.Items[start:end], caption); err != nil {
+			log.Printf("Failed to send stories: %v", err)
+			reply := tgbotapi.NewMessage(result.ChatID, T(lang, "error.upload_failed"))
+			reply.ReplyToMessageID = result.MessageID
+			h.send(reply)
+			return
+		}
+	}
+}
+
+type inputMedia struct {
+	Type    string `json:"type"`
+	Media   string `json:"media"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// sendMediaGroup uploads items as one album, or as a single photo or video
+// when there is only one item, since albums need at least two.
+func (h *Handler) sendMediaGroup(result DownloadResult, items []MediaItem, caption string) error {
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(result.ChatID, 10),
+	}
+	if result.MessageID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(result.MessageID)
+	}
+
+	if len(items) == 1 {
+		item := items[0]
+		params["caption"] = caption
+		method := "sendVideo"
+		if item.Type == "photo" {
+			method = "sendPhoto"
+		}
+		_, err := uploadFiles(h.bot, h.store, method, params, map[string]string{item.Type: item.Path})
+		return err
+	}
+
+	media := make([]inputMedia, len(items))
+	files := make(map[string]string, len(items))
+	for i, item := range items {
+		field := fmt.Sprintf("file%d", i)
+		media[i] = inputMedia{Type: item.Type, Media: "attach://" + field}
+		files[field] = item.Path
+	}
+	media[0].Caption = caption
+
+	data, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+	params["media"] = string(data)
+
+	_, err = uploadFiles(h.bot, h.store, "sendMediaGroup", params, files)
+	return err
+}
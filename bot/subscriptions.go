@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+var (
+	countSubscriptionsQuery = `SELECT COUNT(*) FROM subscriptions WHERE chat_id = ?`
+	insertSubscriptionQuery = `INSERT INTO subscriptions (chat_id, user_id, username) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`
+	insertProfileQuery      = `INSERT INTO followed_profiles (username) VALUES (?) ON CONFLICT DO NOTHING`
+	deleteSubscriptionQuery = `DELETE FROM subscriptions WHERE chat_id = ? AND username = ?`
+	listSubscriptionsQuery  = `SELECT username FROM subscriptions WHERE chat_id = ? ORDER BY username`
+)
+
+func parseUsername(arg string) (string, bool) {
+	username := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(arg), "@"))
+	return username, instagramUsername.MatchString(username)
+}
+
+// handleFollowCommand subscribes the chat to new posts of /follow <username>.
+// The scheduler service polls followed profiles and queues their new posts.
+func (h *Handler) handleFollowCommand(message *tgbotapi.Message, lang string) {
+	username, ok := parseUsername(message.CommandArguments())
+	if !ok {
+		h.reply(message, T(lang, "error.invalid_username"))
+		return
+	}
+
+	var count int
+	err := h.db.QueryRow(countSubscriptionsQuery, message.Chat.ID).Scan(&count)
+	if err != nil {
+		log.Printf("Failed to count subscriptions: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+	if count >= h.maxSubscriptions {
+		h.reply(message, T(lang, "follow.limit", h.maxSubscriptions))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		log.Printf("Failed to save subscription: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(insertSubscriptionQuery, message.Chat.ID, message.From.ID, username)
+	if err == nil {
+		_, err = tx.Exec(insertProfileQuery, username)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to save subscription: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+
+	if added, _ := res.RowsAffected(); added == 0 {
+		h.reply(message, T(lang, "follow.exists", username))
+		return
+	}
+	h.reply(message, T(lang, "follow.added", username))
+}
+
+func (h *Handler) handleUnfollowCommand(message *tgbotapi.Message, lang string) {
+	username, ok := parseUsername(message.CommandArguments())
+	if !ok {
+		h.reply(message, T(lang, "error.invalid_username"))
+		return
+	}
+
+	res, err := h.db.Exec(deleteSubscriptionQuery, message.Chat.ID, username)
+	if err != nil {
+		log.Printf("Failed to delete subscription: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+	if removed, _ := res.RowsAffected(); removed == 0 {
+		h.reply(message, T(lang, "follow.not_found", username))
+		return
+	}
+	h.reply(message, T(lang, "follow.removed", username))
+}
+
+func (h *Handler) handleFollowingCommand(message *tgbotapi.Message, lang string) {
+	rows, err := h.db.Query(listSubscriptionsQuery, message.Chat.ID)
+	if err != nil {
+		log.Printf("Failed to list subscriptions: %v", err)
+		h.reply(message, T(lang, "error.settings_failed"))
+		return
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			log.Printf("Failed to list subscriptions: %v", err)
+			h.reply(message, T(lang, "error.settings_failed"))
+			return
+		}
+		usernames = append(usernames, "@"+username)
+	}
+
+	if len(usernames) == 0 {
+		h.reply(message, T(lang, "follow.none"))
+		return
+	}
+	h.reply(message, T(lang, "follow.list", strings.Join(usernames, "\n")))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"instaVideoDownloaderBot/storage"
+)
+
+// uploadFiles calls a Bot API method with several attached files. The
+// library only supports a single file per request, so thumbnails and media
+// groups are uploaded with this helper. files maps form field names to keys
+// in st, which are streamed to Telegram; empty keys are skipped.
+func uploadFiles(bot *tgbotapi.BotAPI, st storage.Storage, method string, params map[string]string, files map[string]string) (tgbotapi.APIResponse, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		err := writeMultipart(st, form, params, files)
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	endpoint := fmt.Sprintf(tgbotapi.APIEndpoint, bot.Token, method)
+	req, err := http.NewRequest(http.MethodPost, endpoint, pr)
+	if err != nil {
+		pr.Close()
+		return tgbotapi.APIResponse{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		return tgbotapi.APIResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp tgbotapi.APIResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return apiResp, err
+	}
+	if !apiResp.Ok {
+		parameters := tgbotapi.ResponseParameters{}
+		if apiResp.Parameters != nil {
+			parameters = *apiResp.Parameters
+		}
+		return apiResp, tgbotapi.Error{Message: apiResp.Description, ResponseParameters: parameters}
+	}
+	return apiResp, nil
+}
+
+func writeMultipart(st storage.Storage, form *multipart.Writer, params map[string]string, files map[string]string) error {
+	for key, value := range params {
+		if err := form.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	for field, key := range files {
+		if key == "" {
+			continue
+		}
+		file, err := st.Get(context.Background(), key)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", key, err)
+		}
+		part, err := form.CreateFormFile(field, path.Base(key))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
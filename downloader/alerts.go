@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Alert is a message for the bot admins. The bot forwards it to every chat
+// in ADMIN_CHAT_IDS.
+type Alert struct {
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Alerter publishes alerts to the admin_alerts queue.
+type Alerter struct {
+	ch    *amqp091.Channel
+	queue string
+}
+
+func (a *Alerter) Send(message string) {
+	log.Printf("Alert: %s", message)
+	body, err := json.Marshal(Alert{Source: "downloader", Message: message, Time: time.Now()})
+	if err != nil {
+		log.Printf("Failed to marshal alert: %v", err)
+		return
+	}
+	err = a.ch.Publish(
+		"",
+		a.queue,
+		false,
+		false,
+		amqp091.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
+	if err != nil {
+		log.Printf("Failed to publish alert: %v", err)
+	}
+}
@@ -1,20 +1,21 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rabbitmq/amqp091-go"
-	"io"
+	"instaVideoDownloaderBot/janitor"
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+	"instaVideoDownloaderBot/ytdlp"
 	"log"
-	"math/rand"
-	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -30,59 +31,38 @@ type DownloadTask struct {
 	ChatID    int64    `json:"chat_id"`
 	MessageID int      `json:"message_id"`
 	User      UserInfo `json:"user"`
+	Language  string   `json:"language"`
+	Mode      string   `json:"mode"`
+	Platform  string   `json:"platform"`
 }
 
 type DownloadResult struct {
-	URL          string `json:"url"`
-	FilePath     string `json:"file_path"`
-	Size         int64  `json:"size"`
-	PreviewImage string `json:"preview_image"`
-	Tags         string `json:"tags"`
-	Description  string `json:"description"`
-	ChatID       int64  `json:"chat_id"`
-	MessageID    int    `json:"message_id"`
+	URL          string      `json:"url"`
+	FilePath     string      `json:"file_path"`
+	Size         int64       `json:"size"`
+	PreviewImage string      `json:"preview_image"`
+	Tags         string      `json:"tags"`
+	Description  string      `json:"description"`
+	ChatID       int64       `json:"chat_id"`
+	MessageID    int         `json:"message_id"`
+	Language     string      `json:"language"`
+	Mode         string      `json:"mode"`
+	Title        string      `json:"title"`
+	Performer    string      `json:"performer"`
+	Duration     int         `json:"duration"`
+	Thumbnail    string      `json:"thumbnail"`
+	Items        []MediaItem `json:"items,omitempty"`
+	Platform     string      `json:"platform"`
+	Uploader     string      `json:"uploader"`
+	Error        string      `json:"error,omitempty"`
+	ErrorClass   string      `json:"error_class,omitempty"`
 }
 
 var (
-	cookiesFilePath = os.Getenv("COOKIES_FILE_PATH")
-	databaseFile    = "/app/data/videos.db"
-)
-
-var (
-	createTablesQuery = `
-	CREATE TABLE IF NOT EXISTS processed_urls (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		file_size INTEGER,
-		preview_image TEXT,
-		tags TEXT,
-		description TEXT
-	);
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		username TEXT,
-		first_name TEXT,
-		last_name TEXT,
-		total_bytes_downloaded INTEGER DEFAULT 0
-	);
-	CREATE TABLE IF NOT EXISTS downloads (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		url TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		file_size INTEGER,
-		preview_image TEXT,
-		tags TEXT,
-		description TEXT,
-		FOREIGN KEY (user_id) REFERENCES users (id)
-	);
-	`
-	insertUserQuery         = `INSERT OR IGNORE INTO users (user_id, username, first_name, last_name) VALUES (?, ?, ?, ?)`
-	updateUserQuery         = `UPDATE users SET total_bytes_downloaded = total_bytes_downloaded + ? WHERE user_id = ?`
-	insertDownloadQuery     = `INSERT INTO downloads (user_id, url, file_size, preview_image, tags, description) VALUES (?, ?, ?, ?, ?, ?)`
-	insertProcessedURLQuery = `INSERT INTO processed_urls (url, file_size, preview_image, tags, description) VALUES (?, ?, ?, ?, ?)`
+	databaseFile = "/app/data/videos.db"
+	runner       = &ytdlp.Runner{}
+	thumbnails   = &Thumbnailer{}
+	store        = &MediaStore{}
 )
 
 func GetEnv(key, fallback string) string {
@@ -93,184 +73,207 @@ func GetEnv(key, fallback string) string {
 	return value
 }
 
-func init() {
-	if cookiesFilePath == "" {
-		log.Fatal("COOKIES_FILE_PATH environment variable is not set")
-	}
-	fmt.Printf("Cookies File Path: %s\n", cookiesFilePath)
-	cookiesContent, err := os.ReadFile(cookiesFilePath)
-	if err != nil {
-		log.Fatalf("Failed to read cookies file: %v", err)
-	}
-	fmt.Printf("Cookies File Content:\n%s\n", string(cookiesContent))
-}
-
-func downloadVideo(url string) (string, int64, string, string, string, error) {
+func downloadVideo(p *platform.Platform, proxy *ytdlp.Proxy, url string) (string, MediaInfo, error) {
 	id := uuid.New()
-	// Determine file extension (assume mp4 for simplicity)
-	fileExt := "mp4" // yt-dlp will determine the correct extension
+	// Formats are merged or remuxed into mp4, which Telegram plays inline
+	fileExt := "mp4"
 	outputPath := fmt.Sprintf("/tmp/%s.%s", id.String(), fileExt)
 	log.Println("Starting downloading video to: ", outputPath)
-	cmd := exec.Command("yt-dlp", "-o", fmt.Sprintf("/tmp/%s.%%(ext)s", id.String()), "--cookies", cookiesFilePath, "--write-info-json", url)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Failed to download video: %s", string(output))
-		return "", 0, "", "", "", err
-	}
-	infoFile := fmt.Sprintf("/tmp/%s.info.json", id.String())
-	info, err := os.ReadFile(infoFile)
+	_, stderr, err := runner.Run(p, proxy,
+		"-f", p.Format,
+		"--merge-output-format", fileExt,
+		"--remux-video", fileExt,
+		"-o", fmt.Sprintf("/tmp/%s.%%(ext)s", id.String()),
+		"--write-info-json",
+		url)
 	if err != nil {
-		log.Printf("Failed to read info file: %s", err)
-		return "", 0, "", "", "", err
-	}
-
-	var size int64
-	var previewImage, tags, description string
-	infoJSON := map[string]interface{}{}
-	err = json.Unmarshal(info, &infoJSON)
-	if err != nil {
-		log.Printf("Failed to parse info JSON: %s", err)
-	} else {
-		if fileSize, ok := infoJSON["filesize_approx"].(float64); ok {
-			size = int64(fileSize)
-		} else if fileSize, ok := infoJSON["filesize"].(float64); ok {
-			size = int64(fileSize)
-		}
-		if thumbnail, ok := infoJSON["thumbnail"].(string); ok {
-			previewImage = thumbnail
-		}
-		if tagsList, ok := infoJSON["tags"].([]interface{}); ok {
-			var tagsArr []string
-			for _, tag := range tagsList {
-				if tagStr, ok := tag.(string); ok {
-					tagsArr = append(tagsArr, tagStr)
-				}
-			}
-			tags = strings.Join(tagsArr, ", ")
-		}
-		if desc, ok := infoJSON["description"].(string); ok {
-			description = desc
-		}
+		log.Printf("Failed to download video: %s", string(stderr))
+		return "", MediaInfo{}, err
 	}
 
-	err = os.Remove(infoFile)
+	info, err := readMediaInfo(fmt.Sprintf("/tmp/%s.info.json", id.String()))
 	if err != nil {
-		log.Printf("Failed to delete video file: %s %v", infoFile, err)
-	} else {
-		log.Println("Successfully deleted info file: ", infoFile)
+		return "", MediaInfo{}, err
 	}
 
-	return outputPath, size, previewImage, tags, description, nil
+	return outputPath, info, nil
 }
 
-func initDB() (*sql.DB, error) {
+func initDB() (*repository.Repository, error) {
 	log.Println("Initializing database")
-	db, err := sql.Open("sqlite3", databaseFile)
+	repo, err := repository.Open(databaseFile)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(createTablesQuery)
+	err = migrations.Up(repo.DB())
 	if err != nil {
+		repo.Close()
 		return nil, err
 	}
 
-	return db, nil
+	return repo, nil
 }
 
-// Function to get random user agent
-func getRandomUserAgent() string {
-	userAgents := []string{
-		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15",
-		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:89.0) Gecko/20100101 Firefox/89.0",
+// migrateCommand implements `downloader migrate [up | down [steps] | version]`.
+func migrateCommand(args []string) error {
+	usage := errors.New("usage: downloader migrate [up | down [steps] | version]")
+	db, err := repository.OpenDB(databaseFile)
+	if err != nil {
+		return err
 	}
-	return userAgents[rand.Intn(len(userAgents))]
-}
+	defer db.Close()
 
-// Function to get file extension from content type
-func getFileExtension(contentType string) string {
-	switch contentType {
-	case "image/jpeg":
-		return "jpg"
-	case "image/png":
-		return "png"
-	case "image/gif":
-		return "gif"
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch {
+	case command == "up" && len(args) <= 1:
+		err = migrations.Up(db)
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return usage
+			}
+		}
+		err = migrations.Down(db, steps)
+	case command == "version" && len(args) <= 1:
 	default:
-		return ""
+		return usage
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := migrations.Version(db)
+	if err != nil {
+		return err
 	}
+	log.Printf("Database is at version %d", version)
+	return nil
 }
 
-func DownloadImage(url string) (string, error) {
-	// Get random user agent
-	userAgent := getRandomUserAgent()
+// migrateToPostgresCommand implements `downloader migrate-sqlite-to-postgres
+// [sqlite file]`, which copies the data of the SQLite database to the
+// PostgreSQL database at DATABASE_URL. Both are migrated first.
+func migrateToPostgresCommand(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: downloader migrate-sqlite-to-postgres [sqlite file]")
+	}
+	path := databaseFile
+	if len(args) == 1 {
+		path = args[0]
+	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	src, err := repository.OpenSQLite(path)
 	if err != nil {
-		errMsg := fmt.Sprintf("Failed to create request: %v", err)
-		log.Println(errMsg)
-		return "", err
-	}
-	req.Header.Set("User-Agent", userAgent)
-
-	// Perform HTTP request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("Failed to download image: %v", err)
-		log.Println(errMsg)
-		return "", err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Println("Failed to close response body")
-		}
-	}(resp.Body)
+		return err
+	}
+	defer src.Close()
+	dst, err := repository.OpenDB(path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if dst.Dialect != repository.Postgres {
+		return errors.New("DATABASE_URL must point to the PostgreSQL database to copy to")
+	}
 
-	// Generate filename
-	uuidFilename := uuid.New().String()
-	fileExtension := getFileExtension(resp.Header.Get("Content-Type"))
+	if err := migrations.Up(src); err != nil {
+		return err
+	}
+	if err := migrations.Up(dst); err != nil {
+		return err
+	}
+	if err := repository.CopyToPostgres(src, dst); err != nil {
+		return err
+	}
+	log.Printf("Copied %s to PostgreSQL", path)
+	return nil
+}
 
-	filename := fmt.Sprintf("%s.%s", uuidFilename, fileExtension)
+// record converts the user of a task for the repository.
+func (u UserInfo) record() repository.User {
+	return repository.User{UserID: u.UserID, Username: u.UserName, FirstName: u.FirstName, LastName: u.LastName}
+}
 
-	// Save file
-	currentDate := time.Now().Format("2006_01_02")
-	dirPath := filepath.Join(GetEnv("DOWNLOAD_DIR", "/static"), currentDate)
-	// Create directories if they don't exist
-	err = os.MkdirAll(dirPath, os.ModePerm)
-	if err != nil {
-		fmt.Println("Error creating directories:", err)
-		return "", err
+// recordFailure stores a failed download, so failure rates can be reported
+// per platform and error class.
+func recordFailure(repo *repository.Repository, task DownloadTask, p *platform.Platform, mode string, err error) {
+	dbErr := repo.RecordFailure(repository.Failure{
+		User:       task.User.record(),
+		URL:        task.URL,
+		Platform:   p.Name,
+		Mode:       mode,
+		ErrorClass: string(ytdlp.Class(err)),
+		Error:      err.Error(),
+	})
+	if dbErr != nil {
+		log.Printf("Failed to save failed download: %v", dbErr)
 	}
-	filePath := filepath.Join(dirPath, filename)
-	file, err := os.Create(filePath)
-	if err != nil {
-		errMsg := fmt.Sprintf("Failed to create file: %v", err)
-		log.Println(errMsg)
-		return "", err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Println("Failed to close file")
-		}
-	}(file)
+}
 
-	_, err = io.Copy(file, resp.Body)
+// failTask records a failed task and tells the bot about it.
+func failTask(repo *repository.Repository, ch *amqp091.Channel, queue string, task DownloadTask, p *platform.Platform, mode string, err error) {
+	recordFailure(repo, task, p, mode, err)
+	err = publishResult(ch, queue, DownloadResult{
+		URL:        task.URL,
+		ChatID:     task.ChatID,
+		MessageID:  task.MessageID,
+		Language:   task.Language,
+		Mode:       mode,
+		Platform:   p.Name,
+		Error:      err.Error(),
+		ErrorClass: string(ytdlp.Class(err)),
+	})
 	if err != nil {
-		errMsg := fmt.Sprintf("Failed to save file: %v", err)
-		log.Println(errMsg)
-		return "", err
+		log.Printf("Failed to publish result: %v", err)
 	}
+}
 
-	log.Printf("Image from url: %s downloaded and saved as %s to %s", url, filename, filePath)
+func publishResult(ch *amqp091.Channel, queue string, result DownloadResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
 
-	return filepath.Join(currentDate, filename), nil
+	return ch.Publish(
+		"",
+		queue,
+		false,
+		false,
+		amqp091.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "encrypt-cookies":
+			if err := encryptCookiesCommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "migrate":
+			if err := migrateCommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "migrate-sqlite-to-postgres":
+			if err := migrateToPostgresCommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+	}
+
 	log.Println("Starting downloader service")
 	conn, err := amqp091.Dial(GetEnv("RABBITMQ_URL", ""))
 	if err != nil {
@@ -321,11 +324,85 @@ func main() {
 		log.Fatalf("Failed to declare a queue: %v", err)
 	}
 
-	db, err := initDB()
+	repo, err := initDB()
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
-	defer db.Close()
+	defer repo.Close()
+
+	alertQueue, err := ch.QueueDeclare(
+		"admin_alerts",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatalf("Failed to declare a queue: %v", err)
+	}
+	alerts := &Alerter{ch: ch, queue: alertQueue.Name}
+
+	runner.Cookies, err = ytdlp.LoadCookiePools(repo, alerts, GetEnv("COOKIES_STRATEGY", ytdlp.StrategyRoundRobin))
+	if err != nil {
+		log.Fatalf("Failed to load cookies: %v", err)
+	}
+	recheckInterval, err := time.ParseDuration(GetEnv("COOKIES_RECHECK_INTERVAL", "1h"))
+	if err != nil {
+		log.Fatalf("Invalid COOKIES_RECHECK_INTERVAL: %v", err)
+	}
+	go runner.Revalidate(recheckInterval, GetEnv("COOKIES_PROBE_URL", ""))
+
+	banThreshold, err := strconv.Atoi(GetEnv("PROXY_BAN_THRESHOLD", "3"))
+	if err != nil || banThreshold <= 0 {
+		log.Fatalf("Invalid PROXY_BAN_THRESHOLD: %q", GetEnv("PROXY_BAN_THRESHOLD", "3"))
+	}
+	banDuration, err := time.ParseDuration(GetEnv("PROXY_BAN_DURATION", "30m"))
+	if err != nil {
+		log.Fatalf("Invalid PROXY_BAN_DURATION: %v", err)
+	}
+	thumbnailMaxSize, err := strconv.ParseInt(GetEnv("THUMBNAIL_MAX_SIZE", "10485760"), 10, 64)
+	if err != nil || thumbnailMaxSize <= 0 {
+		log.Fatalf("Invalid THUMBNAIL_MAX_SIZE: %q", GetEnv("THUMBNAIL_MAX_SIZE", "10485760"))
+	}
+	thumbnailRetries, err := strconv.Atoi(GetEnv("THUMBNAIL_RETRIES", "2"))
+	if err != nil || thumbnailRetries < 0 {
+		log.Fatalf("Invalid THUMBNAIL_RETRIES: %q", GetEnv("THUMBNAIL_RETRIES", "2"))
+	}
+	thumbnailTimeout, err := time.ParseDuration(GetEnv("THUMBNAIL_TIMEOUT", "15s"))
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_TIMEOUT: %v", err)
+	}
+	st, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	store = &MediaStore{storage: st, repo: repo}
+
+	janitorConfig, err := janitor.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	janitorConfig.LocalTmpDir = os.TempDir()
+	janitorInterval, err := time.ParseDuration(GetEnv("JANITOR_INTERVAL", "1h"))
+	if err != nil {
+		log.Fatalf("Invalid JANITOR_INTERVAL: %v", err)
+	}
+	if janitorInterval > 0 {
+		go janitor.Schedule(context.Background(), repo.DB(), st, janitorConfig, janitorInterval)
+	}
+
+	runner.Proxies, err = ytdlp.LoadProxyPool(repo, GetEnv("PROXY_LIST", ""), banThreshold, banDuration)
+	if err != nil {
+		log.Fatalf("Failed to load proxies: %v", err)
+	}
+	thumbnails = &Thumbnailer{
+		Store:   store,
+		Proxies: runner.Proxies,
+		Timeout: thumbnailTimeout,
+		Retries: thumbnailRetries,
+		MaxSize: thumbnailMaxSize,
+	}
 
 	forever := make(chan bool)
 
@@ -338,79 +415,100 @@ func main() {
 				continue
 			}
 
-			// Save user information to the database
-			_, err = db.Exec(insertUserQuery, task.User.UserID, task.User.UserName, task.User.FirstName, task.User.LastName)
-			if err != nil {
-				log.Printf("Failed to save user information: %v", err)
-			} else {
-				log.Println("User information saved")
+			p, ok := platform.ByName(task.Platform)
+			if !ok {
+				p, ok = platform.Match(task.URL)
+			}
+			if !ok {
+				// Tasks queued before platforms were introduced, and links
+				// the bot let through, are tried as Instagram.
+				p, _ = platform.ByName("instagram")
 			}
 
-			log.Println("Accepted task for download video from: ", task.URL, "ChatID: ", task.ChatID)
-			filePath, size, previewImageUrl, tags, description, err := downloadVideo(task.URL)
-			log.Println("Completed task for download video from: ", task.URL, "saved to: ", filePath, "size: ", size, "preview image: ", previewImageUrl, "tags: ", tags, "description: ", description)
-			if err != nil {
-				log.Printf("Failed to download video: %v", err)
+			// One proxy per task, so yt-dlp and the thumbnail download
+			// come from the same address.
+			proxy := runner.AcquireProxy()
+
+			if task.Mode == ModeStories {
+				processStories(repo, ch, completionQueue.Name, p, proxy, task)
 				continue
 			}
 
-			previewImage, err := DownloadImage(previewImageUrl)
+			mode := task.Mode
+			if mode != ModeAudio {
+				mode = ModeVideo
+			}
+
+			log.Println("Accepted task for download", mode, "from: ", task.URL, "ChatID: ", task.ChatID)
+			var filePath, thumbPath string
+			var info MediaInfo
+			if mode == ModeAudio {
+				filePath, info, thumbPath, err = downloadAudio(p, proxy, task.URL)
+			} else {
+				filePath, info, err = downloadVideo(p, proxy, task.URL)
+			}
+			size, tags, description := info.Size, info.Tags, info.Description
+			log.Println("Completed task for download", mode, "from: ", task.URL, "saved to: ", filePath, "size: ", size, "preview image: ", info.Thumbnail, "tags: ", tags, "description: ", description)
 			if err != nil {
-				log.Printf("Failed to download preview image: %v", err)
+				log.Printf("Failed to download %s: %v", mode, err)
+				failTask(repo, ch, completionQueue.Name, task, p, mode, err)
+				continue
 			}
 
-			// Save the download information to the database
-			_, err = db.Exec(insertDownloadQuery, task.User.UserID, task.URL, size, previewImage, tags, description)
+			var previewImage string
+			previewImage, thumbPath = thumbnails.Thumbnails(info.Thumbnail, proxy, filePath, thumbPath)
+
+			object, err := store.Put(filePath)
+			if err != nil {
+				log.Printf("Failed to store %s: %v", filePath, err)
+				os.Remove(filePath)
+				// The bot never gets the thumbnail to send and delete.
+				store.DeleteTemporary(thumbPath)
+				failTask(repo, ch, completionQueue.Name, task, p, mode, err)
+				continue
+			}
+			filePath, size = object.Key, object.Size
+
+			// The media is stored, so it is sent even when the records
+			// can't be saved.
+			err = repo.RecordDownload(repository.Download{
+				User:         task.User.record(),
+				URL:          task.URL,
+				Size:         object.Size,
+				PreviewImage: previewImage,
+				Tags:         info.Tags,
+				Description:  info.Description,
+				Platform:     p.Name,
+				Uploader:     info.Uploader,
+				ContentHash:  object.Hash,
+			})
 			if err != nil {
 				log.Printf("Failed to save download information: %v", err)
 			} else {
 				log.Println("Download information saved")
 			}
 
-			_, err = db.Exec(insertProcessedURLQuery, task.URL, size, previewImage, tags, description)
-
-			// Update user total bytes downloaded
-			_, err = db.Exec(updateUserQuery, size, task.User.UserID)
+			result := DownloadResult{
+				URL:          task.URL,
+				FilePath:     filePath,
+				Size:         size,
+				PreviewImage: previewImage,
+				Tags:         tags,
+				Description:  description,
+				ChatID:       task.ChatID,
+				MessageID:    task.MessageID,
+				Language:     task.Language,
+				Mode:         mode,
+				Title:        info.Title,
+				Performer:    info.Uploader,
+				Duration:     info.Duration,
+				Thumbnail:    thumbPath,
+				Platform:     p.Name,
+				Uploader:     info.Uploader,
+			}
+			err = publishResult(ch, completionQueue.Name, result)
 			if err != nil {
-				log.Printf("Failed to update user total bytes downloaded: %v", err)
-			} else {
-				log.Println("User total bytes downloaded updated")
-				if err != nil {
-					log.Printf("Failed to save URL: %v", err)
-				} else {
-					log.Println("URL information saved")
-				}
-
-				result := DownloadResult{
-					URL:          task.URL,
-					FilePath:     filePath,
-					Size:         size,
-					PreviewImage: previewImage,
-					Tags:         tags,
-					Description:  description,
-					ChatID:       task.ChatID,
-					MessageID:    task.MessageID,
-				}
-				body, err := json.Marshal(result)
-				if err != nil {
-					log.Printf("Failed to marshal result: %v", err)
-					continue
-				}
-
-				err = ch.Publish(
-					"",
-					completionQueue.Name,
-					false,
-					false,
-					amqp091.Publishing{
-						ContentType: "application/json",
-						Body:        body,
-					})
-				if err != nil {
-					log.Printf("Failed to publish result: %v", err)
-					continue
-				}
-
+				log.Printf("Failed to publish result: %v", err)
 			}
 		}
 	}()
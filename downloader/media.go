@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/uuid"
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+const (
+	ModeVideo = "video"
+	ModeAudio = "audio"
+)
+
+// Format the audio track is converted to in audio mode: m4a or mp3.
+var audioFormat = GetEnv("AUDIO_FORMAT", "m4a")
+
+// MediaInfo is the subset of the metadata yt-dlp writes with --write-info-json
+// that the bot and the admin use.
+type MediaInfo struct {
+	Size        int64
+	Thumbnail   string
+	Tags        string
+	Description string
+	Title       string
+	Uploader    string
+	Duration    int
+}
+
+// readMediaInfo parses the info file written by yt-dlp and deletes it.
+func readMediaInfo(infoFile string) (MediaInfo, error) {
+	var info MediaInfo
+	data, err := os.ReadFile(infoFile)
+	if err != nil {
+		log.Printf("Failed to read info file: %s", err)
+		return info, err
+	}
+
+	infoJSON := map[string]interface{}{}
+	err = json.Unmarshal(data, &infoJSON)
+	if err != nil {
+		log.Printf("Failed to parse info JSON: %s", err)
+	} else {
+		if fileSize, ok := infoJSON["filesize_approx"].(float64); ok {
+			info.Size = int64(fileSize)
+		} else if fileSize, ok := infoJSON["filesize"].(float64); ok {
+			info.Size = int64(fileSize)
+		}
+		if thumbnail, ok := infoJSON["thumbnail"].(string); ok {
+			info.Thumbnail = thumbnail
+		}
+		if tagsList, ok := infoJSON["tags"].([]interface{}); ok {
+			var tagsArr []string
+			for _, tag := range tagsList {
+				if tagStr, ok := tag.(string); ok {
+					tagsArr = append(tagsArr, tagStr)
+				}
+			}
+			info.Tags = strings.Join(tagsArr, ", ")
+		}
+		if desc, ok := infoJSON["description"].(string); ok {
+			info.Description = desc
+		}
+		for _, key := range []string{"track", "title"} {
+			if title, ok := infoJSON[key].(string); ok && title != "" {
+				info.Title = title
+				break
+			}
+		}
+		for _, key := range []string{"artist", "uploader", "channel"} {
+			if uploader, ok := infoJSON[key].(string); ok && uploader != "" {
+				info.Uploader = uploader
+				break
+			}
+		}
+		if duration, ok := infoJSON["duration"].(float64); ok {
+			info.Duration = int(duration)
+		}
+	}
+
+	err = os.Remove(infoFile)
+	if err != nil {
+		log.Printf("Failed to delete video file: %s %v", infoFile, err)
+	} else {
+		log.Println("Successfully deleted info file: ", infoFile)
+	}
+
+	return info, nil
+}
+
+// downloadAudio extracts the best audio track of url and converts it to
+// audioFormat with ffmpeg. It returns the audio file, its metadata and the
+// path of a JPEG thumbnail, which is empty when yt-dlp could not fetch one.
+func downloadAudio(p *platform.Platform, proxy *ytdlp.Proxy, url string) (string, MediaInfo, string, error) {
+	id := uuid.New().String()
+	outputPath := fmt.Sprintf("/tmp/%s.%s", id, audioFormat)
+	thumbPath := fmt.Sprintf("/tmp/%s.jpg", id)
+	log.Println("Starting downloading audio to: ", outputPath)
+	_, stderr, err := runner.Run(p, proxy,
+		"-f", "bestaudio/best",
+		"-x", "--audio-format", audioFormat,
+		"-o", fmt.Sprintf("/tmp/%s.%%(ext)s", id),
+		"--write-info-json",
+		"--write-thumbnail", "--convert-thumbnails", "jpg",
+		url)
+	if err != nil {
+		log.Printf("Failed to download audio: %s", string(stderr))
+		return "", MediaInfo{}, "", err
+	}
+
+	info, err := readMediaInfo(fmt.Sprintf("/tmp/%s.info.json", id))
+	if err != nil {
+		return "", MediaInfo{}, "", err
+	}
+	info.Title = audioTitle(info)
+
+	err = tagAudio(outputPath, info.Title, info.Uploader)
+	if err != nil {
+		// Untagged audio is still worth sending.
+		log.Printf("Failed to tag audio %s: %v", outputPath, err)
+	}
+
+	stat, err := os.Stat(outputPath)
+	if err != nil {
+		return "", MediaInfo{}, "", err
+	}
+	info.Size = stat.Size()
+
+	if _, err := os.Stat(thumbPath); err != nil {
+		thumbPath = ""
+	}
+
+	return outputPath, info, thumbPath, nil
+}
+
+// audioTitle picks a track title. Instagram titles are generic ("Video by
+// ..."), so the first line of the description is preferred when present.
+func audioTitle(info MediaInfo) string {
+	title := info.Title
+	if line, _, _ := strings.Cut(strings.TrimSpace(info.Description), "\n"); line != "" {
+		title = line
+	}
+	runes := []rune(title)
+	if len(runes) > 64 {
+		title = string(runes[:63]) + "…"
+	}
+	return title
+}
+
+// tagAudio writes title and performer tags into the audio file in place.
+func tagAudio(path, title, performer string) error {
+	tagged := strings.TrimSuffix(path, "."+audioFormat) + ".tagged." + audioFormat
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-i", path,
+		"-map", "0:a", "-c", "copy",
+		"-metadata", "title="+title,
+		"-metadata", "artist="+performer,
+		tagged)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tagged)
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return os.Rename(tagged, path)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+// encryptCookiesCommand implements `downloader encrypt-cookies <in> <out>`.
+func encryptCookiesCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: downloader encrypt-cookies <cookies.txt> <cookies.txt.enc>")
+	}
+	plain, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if ytdlp.IsEncrypted(plain) {
+		return fmt.Errorf("%s is already encrypted", args[0])
+	}
+	sealed, err := ytdlp.EncryptCookies(plain)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(args[1], sealed, 0600)
+	if err != nil {
+		return err
+	}
+	log.Printf("Encrypted %s to %s (%s)", args[0], args[1], ytdlp.CookiesSummary(plain))
+	return nil
+}
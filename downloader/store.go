@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+)
+
+// MediaObject is a stored file. Key is its storage key.
+type MediaObject struct {
+	Hash string
+	Key  string
+	Size int64
+}
+
+// MediaStore keeps downloaded media and previews once per content under
+// objects/<hash[0:2]>/<hash[2:4]>/<hash><ext>. media_objects counts the
+// downloads referring to each object and records when it was last used,
+// which is what the janitor expires them by.
+type MediaStore struct {
+	storage storage.Storage
+	repo    *repository.Repository
+}
+
+// Put uploads the local file at filePath to the storage, unless the same
+// content is already stored, and marks the object as used. The local file is
+// deleted either way.
+func (s *MediaStore) Put(filePath string) (MediaObject, error) {
+	hash, size, err := hashFile(filePath)
+	if err != nil {
+		return MediaObject{}, err
+	}
+
+	key, err := s.repo.MediaObjectKey(hash)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return MediaObject{}, err
+	}
+	if key == "" {
+		key = path.Join("objects", hash[0:2], hash[2:4], hash+strings.ToLower(filepath.Ext(filePath)))
+	}
+	// Marking the object as used before checking the storage keeps the
+	// janitor from deleting it in between.
+	err = s.repo.SaveMediaObject(hash, key, size)
+	if err != nil {
+		return MediaObject{}, err
+	}
+
+	stored, err := s.exists(key)
+	if err != nil {
+		return MediaObject{}, err
+	}
+	if stored {
+		log.Printf("%s is already stored as %s", filePath, key)
+	} else {
+		err = s.upload(key, filePath)
+		if err != nil {
+			return MediaObject{}, err
+		}
+	}
+	s.removeLocal(filePath)
+	return MediaObject{Hash: hash, Key: key, Size: size}, nil
+}
+
+// exists reports whether an object is stored under key.
+func (s *MediaStore) exists(key string) (bool, error) {
+	r, err := s.storage.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.Close()
+	return true, nil
+}
+
+// PutTemporary uploads a file the bot needs only once, such as a Telegram
+// thumbnail, under tmp/. The bot deletes it after use. The local file is
+// deleted.
+func (s *MediaStore) PutTemporary(filePath string) (string, error) {
+	key := "tmp/" + uuid.New().String() + strings.ToLower(filepath.Ext(filePath))
+	err := s.upload(key, filePath)
+	if err != nil {
+		return "", err
+	}
+	s.removeLocal(filePath)
+	return key, nil
+}
+
+// DeleteTemporary deletes a file PutTemporary stored that won't be used
+// after all. An empty key is ignored.
+func (s *MediaStore) DeleteTemporary(key string) {
+	if key == "" {
+		return
+	}
+	if err := s.storage.Delete(context.Background(), key); err != nil {
+		log.Printf("Failed to delete %s: %v", key, err)
+	}
+}
+
+func (s *MediaStore) upload(key, filePath string) error {
+	return storage.PutFile(context.Background(), s.storage, key, filePath, mime.TypeByExtension(filepath.Ext(filePath)))
+}
+
+func (s *MediaStore) removeLocal(filePath string) {
+	if err := os.Remove(filePath); err != nil {
+		log.Printf("Failed to delete %s: %v", filePath, err)
+	}
+}
+
+func hashFile(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
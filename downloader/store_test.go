@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"instaVideoDownloaderBot/storage"
+)
+
+// TestPutRestoresMissingObject checks that content whose file is gone, for
+// instance deleted by the janitor, is uploaded again rather than skipped.
+func TestPutRestoresMissingObject(t *testing.T) {
+	st := storage.NewLocal(t.TempDir(), "")
+	store := &MediaStore{storage: st, repo: testRepository(t)}
+	put := func() MediaObject {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "video.mp4")
+		if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		object, err := store.Put(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("local file is left: %v", err)
+		}
+		return object
+	}
+
+	first := put()
+	if err := st.Delete(context.Background(), first.Key); err != nil {
+		t.Fatal(err)
+	}
+	second := put()
+	if second.Key != first.Key {
+		t.Errorf("got key %q, want %q", second.Key, first.Key)
+	}
+	r, err := st.Get(context.Background(), second.Key)
+	if err != nil {
+		t.Fatalf("object is not stored again: %v", err)
+	}
+	r.Close()
+}
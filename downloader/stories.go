@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+const ModeStories = "stories"
+
+// MediaItem is one photo or video of a story reel or highlight. Path is its
+// storage key.
+type MediaItem struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	TakenAt  int64  `json:"taken_at"`
+	Size     int64  `json:"size"`
+	StoryURL string `json:"story_url"`
+}
+
+type storyEntry struct {
+	Index     int
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type storyPlaylist struct {
+	Uploader string       `json:"uploader"`
+	Entries  []storyEntry `json:"entries"`
+}
+
+// downloadStories downloads the items of a story reel or highlight that were
+// not yet delivered to chatID. It returns the owner of the stories and the new
+// items.
+func downloadStories(repo *repository.Repository, p *platform.Platform, proxy *ytdlp.Proxy, url string, chatID int64) (string, []MediaItem, error) {
+	output, stderr, err := runner.Run(p, proxy, "-J", url)
+	if err != nil {
+		log.Printf("Failed to list stories: %s", string(stderr))
+		return "", nil, err
+	}
+
+	var playlist storyPlaylist
+	err = json.Unmarshal(output, &playlist)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse stories JSON: %w", err)
+	}
+
+	var fresh []storyEntry
+	var indexes []string
+	for i, entry := range playlist.Entries {
+		entry.Index = i + 1
+		seen, err := repo.StorySeen(entry.ID, chatID)
+		if err != nil {
+			return "", nil, err
+		}
+		if seen {
+			continue
+		}
+		fresh = append(fresh, entry)
+		indexes = append(indexes, strconv.Itoa(entry.Index))
+	}
+	if len(fresh) == 0 {
+		log.Println("No new stories at: ", url)
+		return playlist.Uploader, nil, nil
+	}
+
+	batch := uuid.New().String()
+	_, stderr, err = runner.Run(p, proxy,
+		"--playlist-items", strings.Join(indexes, ","),
+		"-o", fmt.Sprintf("/tmp/%s_%%(id)s.%%(ext)s", batch),
+		url)
+	if err != nil {
+		log.Printf("Failed to download stories: %s", string(stderr))
+		return "", nil, err
+	}
+
+	var items []MediaItem
+	for _, entry := range fresh {
+		matches, _ := filepath.Glob(fmt.Sprintf("/tmp/%s_%s.*", batch, entry.ID))
+		if len(matches) == 0 {
+			log.Printf("Story %s was not downloaded", entry.ID)
+			continue
+		}
+		path := matches[0]
+		stat, err := os.Stat(path)
+		if err != nil {
+			return "", nil, err
+		}
+		items = append(items, MediaItem{
+			ID:       entry.ID,
+			Path:     path,
+			Type:     mediaType(path),
+			TakenAt:  entry.Timestamp,
+			Size:     stat.Size(),
+			StoryURL: fmt.Sprintf("https://www.instagram.com/stories/%s/%s/", playlist.Uploader, entry.ID),
+		})
+	}
+
+	return playlist.Uploader, items, nil
+}
+
+func mediaType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".webp", ".heic":
+		return "photo"
+	default:
+		return "video"
+	}
+}
+
+// processStories handles a stories task: it downloads the new items, records
+// them so they are skipped next time, and sends them back as one result.
+func processStories(repo *repository.Repository, ch *amqp091.Channel, queue string, p *platform.Platform, proxy *ytdlp.Proxy, task DownloadTask) {
+	log.Println("Accepted task for download stories from: ", task.URL, "ChatID: ", task.ChatID)
+	result := DownloadResult{
+		URL:       task.URL,
+		ChatID:    task.ChatID,
+		MessageID: task.MessageID,
+		Language:  task.Language,
+		Mode:      ModeStories,
+		Platform:  p.Name,
+	}
+
+	owner, items, err := downloadStories(repo, p, proxy, task.URL, task.ChatID)
+	if err != nil {
+		log.Printf("Failed to download stories: %v", err)
+		result.Error = err.Error()
+		result.ErrorClass = string(ytdlp.Class(err))
+		recordFailure(repo, task, p, ModeStories, err)
+	} else {
+		result.Performer = owner
+	}
+
+	var stored []MediaItem
+	var records []repository.StoryItem
+	for _, item := range items {
+		object, err := store.Put(item.Path)
+		if err != nil {
+			log.Printf("Failed to store story item %s: %v", item.ID, err)
+			os.Remove(item.Path)
+			continue
+		}
+		item.Path, item.Size = object.Key, object.Size
+		stored = append(stored, item)
+		records = append(records, repository.StoryItem{
+			StoryID:     item.ID,
+			TakenAt:     item.TakenAt,
+			URL:         item.StoryURL,
+			Size:        item.Size,
+			ContentHash: object.Hash,
+		})
+	}
+
+	if len(records) > 0 {
+		err = repo.RecordStories(task.User.record(), task.ChatID, p.Name, owner, records)
+		if err != nil {
+			log.Printf("Failed to save stories: %v", err)
+		}
+	}
+
+	result.Items = stored
+
+	err = publishResult(ch, queue, result)
+	if err != nil {
+		log.Printf("Failed to publish result: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/google/uuid"
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:89.0) Gecko/20100101 Firefox/89.0",
+}
+
+// Function to get random user agent
+func getRandomUserAgent() string {
+	return userAgents[rand.Intn(len(userAgents))]
+}
+
+// Widths of the normalized thumbnails. Telegram ignores upload thumbnails
+// larger than 320px, and shows them at 90px.
+const (
+	previewWidth       = 320
+	telegramThumbWidth = 90
+)
+
+// Thumbnailer fetches remote preview images and renders the normalized
+// thumbnails: a preview JPEG in Store for the admin and a small temporary
+// JPEG for the Telegram upload. Fetches through a proxy are reported to
+// Proxies, which may be nil.
+type Thumbnailer struct {
+	Store   *MediaStore
+	Proxies *ytdlp.ProxyPool
+	Timeout time.Duration
+	Retries int
+	MaxSize int64
+}
+
+// statusError is a non-200 response. Server errors and 429 are retried.
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+func (e *statusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var (
+	errNoThumbnail   = errors.New("no thumbnail URL")
+	errNotAnImage    = errors.New("response is not a supported image")
+	errImageTooLarge = errors.New("image exceeds the size limit")
+)
+
+// Fetch downloads the image at url through proxy into /tmp and returns its
+// path.
+func (f *Thumbnailer) Fetch(url string, proxy *ytdlp.Proxy) (string, error) {
+	if url == "" {
+		return "", errNoThumbnail
+	}
+
+	client := ytdlp.HTTPClient(proxy, f.Timeout)
+
+	var data []byte
+	var err error
+	for attempt := 0; attempt <= f.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+			log.Printf("Retrying image download (%d/%d): %v", attempt, f.Retries, err)
+		}
+		data, err = f.get(client, url)
+		if f.Proxies != nil {
+			f.Proxies.Report(proxy, transportError(err))
+		}
+		var statusErr *statusError
+		if err == nil || errors.Is(err, errImageTooLarge) || (errors.As(err, &statusErr) && !statusErr.temporary()) {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download image %s: %w", url, err)
+	}
+
+	ext := imageExtension(data)
+	if ext == "" {
+		return "", fmt.Errorf("failed to download image %s: %w", url, errNotAnImage)
+	}
+
+	path := fmt.Sprintf("/tmp/%s.%s", uuid.New().String(), ext)
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Image from url: %s downloaded to %s", url, path)
+	return path, nil
+}
+
+// Thumbnails renders the preview and the Telegram thumbnail of a download.
+// The sources are tried in order: the thumbnail yt-dlp wrote next to the
+// media (localThumb), the remote thumbnail and a frame of the video. It
+// returns the storage keys of the preview and the Telegram thumbnail;
+// either is empty when no source worked. localThumb is removed.
+func (f *Thumbnailer) Thumbnails(url string, proxy *ytdlp.Proxy, mediaPath, localThumb string) (string, string) {
+	sources := []func() (string, error){
+		func() (string, error) {
+			if localThumb == "" {
+				return "", errNoThumbnail
+			}
+			return localThumb, nil
+		},
+		func() (string, error) {
+			return f.Fetch(url, proxy)
+		},
+		func() (string, error) {
+			return videoFrame(mediaPath)
+		},
+	}
+
+	for _, source := range sources {
+		path, err := source()
+		if err != nil {
+			log.Printf("No thumbnail source: %v", err)
+			continue
+		}
+		preview, thumb, err := f.render(path)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to delete thumbnail source %s: %v", path, err)
+		}
+		if err != nil {
+			log.Printf("Failed to render thumbnails: %v", err)
+			continue
+		}
+		return preview, thumb
+	}
+	return "", ""
+}
+
+// render scales source into the preview and the Telegram thumbnail.
+func (f *Thumbnailer) render(source string) (string, string, error) {
+	id := uuid.New().String()
+	preview := fmt.Sprintf("/tmp/%s_preview.jpg", id)
+	err := scaleImage(source, preview, previewWidth)
+	if err != nil {
+		return "", "", err
+	}
+	thumb := fmt.Sprintf("/tmp/%s_thumb.jpg", id)
+	err = scaleImage(source, thumb, telegramThumbWidth)
+	if err != nil {
+		os.Remove(preview)
+		return "", "", err
+	}
+
+	object, err := f.Store.Put(preview)
+	if err != nil {
+		os.Remove(preview)
+		os.Remove(thumb)
+		return "", "", err
+	}
+	thumbKey, err := f.Store.PutTemporary(thumb)
+	if err != nil {
+		os.Remove(thumb)
+		return "", "", err
+	}
+	return object.Key, thumbKey, nil
+}
+
+// scaleImage writes a JPEG of source that fits into width x width. Smaller
+// images are not upscaled.
+func scaleImage(source, target string, width int) error {
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-i", source,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease", width, width),
+		"-q:v", "4",
+		target)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// videoFrame extracts a representative frame of a video into /tmp.
+func videoFrame(videoPath string) (string, error) {
+	frame := fmt.Sprintf("/tmp/%s_frame.jpg", uuid.New().String())
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-i", videoPath,
+		"-vf", "thumbnail",
+		"-frames:v", "1",
+		frame)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(frame)
+		return "", fmt.Errorf("failed to extract a frame of %s: %v: %s", videoPath, err, output)
+	}
+	return frame, nil
+}
+
+func (f *Thumbnailer) get(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", getRandomUserAgent())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+	if resp.ContentLength > f.MaxSize {
+		return nil, errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.MaxSize {
+		return nil, errImageTooLarge
+	}
+	return data, nil
+}
+
+// transportError returns err when the request failed before or while the
+// response came in, and nil otherwise. Any response, even a 404 or an image
+// over the size limit, shows that the proxy works.
+func transportError(err error) error {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) || errors.Is(err, errImageTooLarge) {
+		return nil
+	}
+	return err
+}
+
+// imageExtension detects the image format from its magic bytes. CDNs often
+// send a generic or wrong Content-Type, so the header is not trusted.
+func imageExtension(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		// ISO BMFF: the major brand tells HEIF images from videos.
+		switch string(data[8:12]) {
+		case "heic", "heix", "heim", "heis", "mif1", "msf1":
+			return "heic"
+		case "avif", "avis":
+			return "avif"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+func testRepository(t *testing.T) *repository.Repository {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	repo, err := repository.Open(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := migrations.Up(repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serve starts a server answering every request with handler and counts the
+// requests.
+func serve(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func fetch(t *testing.T, f *Thumbnailer, url string, proxy *ytdlp.Proxy) (string, error) {
+	t.Helper()
+	path, err := f.Fetch(url, proxy)
+	if path != "" {
+		t.Cleanup(func() { os.Remove(path) })
+	}
+	return path, err
+}
+
+func TestFetchStatus(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		hits   int32
+	}{
+		{http.StatusNotFound, 1},
+		{http.StatusForbidden, 1},
+		{http.StatusServiceUnavailable, 2},
+		{http.StatusTooManyRequests, 2},
+	} {
+		server, hits := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		})
+		f := &Thumbnailer{Timeout: time.Second, Retries: 1, MaxSize: 1 << 20}
+		_, err := fetch(t, f, server.URL, nil)
+
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != test.status {
+			t.Errorf("status %d: got error %v", test.status, err)
+		}
+		if *hits != test.hits {
+			t.Errorf("status %d: got %d requests, want %d", test.status, *hits, test.hits)
+		}
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	data := append(testPNG(t), make([]byte, 64)...)
+	for name, handler := range map[string]http.HandlerFunc{
+		"content length": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+		"chunked": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data[:8])
+			w.(http.Flusher).Flush()
+			w.Write(data[8:])
+		},
+	} {
+		server, hits := serve(t, handler)
+		f := &Thumbnailer{Timeout: time.Second, Retries: 1, MaxSize: int64(len(data) - 1)}
+		_, err := fetch(t, f, server.URL, nil)
+		if !errors.Is(err, errImageTooLarge) {
+			t.Errorf("%s: got error %v, want %v", name, err, errImageTooLarge)
+		}
+		if *hits != 1 {
+			t.Errorf("%s: got %d requests, want 1", name, *hits)
+		}
+
+		f.MaxSize = int64(len(data))
+		if _, err := fetch(t, f, server.URL, nil); err != nil {
+			t.Errorf("%s: image of exactly the limit: %v", name, err)
+		}
+	}
+}
+
+func TestFetchSniffsContent(t *testing.T) {
+	png := testPNG(t)
+	server, _ := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("<html>not found</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(png)
+	})
+	f := &Thumbnailer{Timeout: time.Second, MaxSize: 1 << 20}
+
+	path, err := fetch(t, f, server.URL+"/image", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(path) != ".png" {
+		t.Errorf("got %s, want a .png file", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, png) {
+		t.Errorf("saved image differs from the response: %v", err)
+	}
+
+	_, err = fetch(t, f, server.URL+"/page", nil)
+	if !errors.Is(err, errNotAnImage) {
+		t.Errorf("got error %v, want %v", err, errNotAnImage)
+	}
+}
+
+func TestImageExtension(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		ext  string
+	}{
+		{"\xFF\xD8\xFF\xE0rest", "jpg"},
+		{"\x89PNG\r\n\x1a\nrest", "png"},
+		{"GIF89arest", "gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"\x00\x00\x00\x18ftypheic", "heic"},
+		{"\x00\x00\x00\x18ftypavif", "avif"},
+		{"\x00\x00\x00\x18ftypisom", ""},
+		{"<html>", ""},
+		{"", ""},
+	} {
+		if ext := imageExtension([]byte(test.data)); ext != test.ext {
+			t.Errorf("imageExtension(%q) = %q, want %q", test.data, ext, test.ext)
+		}
+	}
+}
+
+// TestFetchReportsTransportErrors checks that only failed requests count
+// against a proxy: with a ban threshold of one, any reported failure bans it.
+func TestFetchReportsTransportErrors(t *testing.T) {
+	repo := testRepository(t)
+	// A proxy receives the absolute URL and answers like the CDN would.
+	proxyServer, _ := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.jpg":
+			w.WriteHeader(http.StatusNotFound)
+		case "/large.jpg":
+			w.Write(make([]byte, 2048))
+		default:
+			w.Write(testPNG(t))
+		}
+	})
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	pool, err := ytdlp.LoadProxyPool(repo, proxyServer.URL+","+dead.URL, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	working, broken := pool.Acquire(), pool.Acquire()
+	f := &Thumbnailer{Proxies: pool, Timeout: time.Second, MaxSize: 1024}
+
+	for _, path := range []string{"/missing.jpg", "/large.jpg", "/image.png"} {
+		fetch(t, f, "http://cdn.invalid"+path, working)
+	}
+	if working.Failures != 0 || working.Successes != 3 {
+		t.Errorf("working proxy: got %d failures and %d successes, want 0 and 3", working.Failures, working.Successes)
+	}
+
+	if _, err := fetch(t, f, "http://cdn.invalid/image.png", broken); err == nil {
+		t.Fatal("fetch through a closed proxy succeeded")
+	}
+	if broken.Failures != 1 || broken.BannedUntil.IsZero() {
+		t.Errorf("broken proxy: got %d failures, banned until %v", broken.Failures, broken.BannedUntil)
+	}
+}
+
+// TestThumbnailsFallback checks the order of the thumbnail sources: the
+// remote image is used when yt-dlp wrote none, and nothing is stored when no
+// source works.
+func TestThumbnailsFallback(t *testing.T) {
+	repo := testRepository(t)
+	server, _ := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(testPNG(t))
+	})
+	f := &Thumbnailer{
+		Store:   &MediaStore{storage: storage.NewLocal(t.TempDir(), ""), repo: repo},
+		Timeout: time.Second,
+		MaxSize: 1 << 20,
+	}
+
+	preview, thumb := f.Thumbnails(server.URL+"/missing.jpg", nil, filepath.Join(t.TempDir(), "missing.mp4"), "")
+	if preview != "" || thumb != "" {
+		t.Errorf("without a source: got %q and %q", preview, thumb)
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	preview, thumb = f.Thumbnails(server.URL+"/image.png", nil, filepath.Join(t.TempDir(), "missing.mp4"), "")
+	if !strings.HasPrefix(preview, "objects/") || !strings.HasPrefix(thumb, "tmp/") {
+		t.Errorf("from the remote image: got %q and %q", preview, thumb)
+	}
+}
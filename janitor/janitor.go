@@ -0,0 +1,433 @@
+// Package janitor enforces the retention rules of stored media: it deletes
+// objects that are too old, evicts the least recently used ones when the
+// store is over its disk budget, and removes objects and files nothing
+// refers to. The
+// downloader runs it on a schedule and the admin on demand, optionally as a
+// dry run that only reports what would be deleted.
+package janitor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+)
+
+// Reasons an object is deleted for.
+const (
+	ReasonExpired    = "expired"
+	ReasonOverBudget = "over_budget"
+	ReasonOrphan     = "orphan"
+	ReasonTemporary  = "temporary"
+	ReasonLocalTmp   = "local_tmp"
+)
+
+var (
+	expiredObjectsQuery = `SELECT hash, key, size, last_used_at FROM media_objects
+		WHERE last_used_at < ? ORDER BY last_used_at`
+	lruObjectsQuery = `SELECT hash, key, size, last_used_at FROM media_objects
+		ORDER BY last_used_at, created_at`
+	unreferencedObjectsQuery = `SELECT hash, key, size, last_used_at FROM media_objects
+		WHERE ref_count = 0 AND created_at < ? ORDER BY created_at`
+	totalSizeQuery     = `SELECT COALESCE(SUM(size), 0) FROM media_objects`
+	referencedKeyQuery = `SELECT 1 FROM media_objects WHERE key = ?
+		UNION ALL SELECT 1 FROM processed_urls WHERE preview_image = ?
+		UNION ALL SELECT 1 FROM downloads WHERE preview_image = ?
+		LIMIT 1`
+	// deleteObjectQuery only deletes the object when nothing used it since
+	// the janitor read its last_used_at: a download of the same content
+	// marks it as used before relying on it.
+	deleteObjectQuery        = `DELETE FROM media_objects WHERE hash = ? AND last_used_at <= ?`
+	objectExistsQuery        = `SELECT 1 FROM media_objects WHERE hash = ?`
+	clearProcessedURLPreview = `UPDATE processed_urls SET preview_image = NULL WHERE preview_image = ?`
+	clearDownloadPreview     = `UPDATE downloads SET preview_image = NULL WHERE preview_image = ?`
+	insertRunQuery           = `INSERT INTO janitor_runs (started_at, finished_at, trigger, dry_run, deleted_count, deleted_bytes, error_count, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+// Config holds the retention rules. Zero values disable a rule.
+type Config struct {
+	// MaxAge deletes media not used for longer than this.
+	MaxAge time.Duration
+	// DiskBudget is the total size of media to keep, in bytes. The least
+	// recently used objects are deleted beyond it.
+	DiskBudget int64
+	// TmpAge is how long temporary objects (tmp/) and files in LocalTmpDir
+	// are kept.
+	TmpAge time.Duration
+	// OrphanGrace protects unreferenced objects younger than this, which
+	// may belong to a download in progress.
+	OrphanGrace time.Duration
+	// LocalTmpDir is swept for leftovers of crashed downloads. Only the
+	// downloader sets it, since only it writes there.
+	LocalTmpDir string
+}
+
+// ConfigFromEnv reads the JANITOR_* variables.
+func ConfigFromEnv() (Config, error) {
+	var config Config
+	var err error
+	durations := []struct {
+		key, fallback string
+		target        *time.Duration
+	}{
+		{"JANITOR_MAX_AGE", "720h", &config.MaxAge},
+		{"JANITOR_TMP_AGE", "6h", &config.TmpAge},
+		{"JANITOR_ORPHAN_GRACE", "1h", &config.OrphanGrace},
+	}
+	for _, d := range durations {
+		*d.target, err = time.ParseDuration(getEnv(d.key, d.fallback))
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+	}
+	config.DiskBudget, err = strconv.ParseInt(getEnv("JANITOR_DISK_BUDGET", "0"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid JANITOR_DISK_BUDGET: %w", err)
+	}
+	return config, nil
+}
+
+// Action is one object the janitor deleted, or would delete in a dry run.
+type Action struct {
+	Reason   string    `json:"reason"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Report struct {
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Trigger      string
+	DryRun       bool
+	Actions      []Action
+	DeletedBytes int64
+	Errors       int
+}
+
+type janitor struct {
+	ctx    context.Context
+	db     *repository.DB
+	st     storage.Storage
+	config Config
+	report *Report
+	// deleted holds the keys handled by an earlier rule of the same run.
+	deleted map[string]bool
+}
+
+// Run applies the rules once. trigger says who started the run and is kept
+// in the report.
+func Run(ctx context.Context, db *repository.DB, st storage.Storage, config Config, trigger string, dryRun bool) (*Report, error) {
+	report := &Report{StartedAt: time.Now(), Trigger: trigger, DryRun: dryRun}
+	j := &janitor{ctx: ctx, db: db, st: st, config: config, report: report, deleted: map[string]bool{}}
+
+	defer func() {
+		report.FinishedAt = time.Now()
+	}()
+
+	steps := []func() error{j.expired, j.overBudget, j.unreferenced, j.orphans, j.localTmp}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			report.Errors++
+			return report, err
+		}
+	}
+	log.Printf("Janitor (%s, dry run: %t) handled %d objects, %d bytes, %d errors",
+		trigger, dryRun, len(report.Actions), report.DeletedBytes, report.Errors)
+	return report, nil
+}
+
+type mediaObject struct {
+	Hash     string
+	Key      string
+	Size     int64
+	LastUsed time.Time
+}
+
+func (j *janitor) queryObjects(query string, args ...interface{}) ([]mediaObject, error) {
+	rows, err := j.db.QueryContext(j.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []mediaObject
+	for rows.Next() {
+		var o mediaObject
+		if err := rows.Scan(&o.Hash, &o.Key, &o.Size, &o.LastUsed); err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, rows.Err()
+}
+
+func (j *janitor) expired() error {
+	if j.config.MaxAge <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-j.config.MaxAge).UTC().Format(time.DateTime)
+	objects, err := j.queryObjects(expiredObjectsQuery, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		j.deleteObject(ReasonExpired, o)
+	}
+	return nil
+}
+
+func (j *janitor) overBudget() error {
+	if j.config.DiskBudget <= 0 {
+		return nil
+	}
+	var total int64
+	err := j.db.QueryRowContext(j.ctx, totalSizeQuery).Scan(&total)
+	if err != nil {
+		return err
+	}
+	// In a dry run the expired objects are still counted in the table.
+	if j.report.DryRun {
+		for _, action := range j.report.Actions {
+			total -= action.Size
+		}
+	}
+	if total <= j.config.DiskBudget {
+		return nil
+	}
+
+	objects, err := j.queryObjects(lruObjectsQuery)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if total <= j.config.DiskBudget {
+			break
+		}
+		if j.deleted[o.Key] {
+			continue
+		}
+		if j.deleteObject(ReasonOverBudget, o) {
+			total -= o.Size
+		}
+	}
+	return nil
+}
+
+// unreferenced deletes media objects no download ever referred to, such as
+// the media of a download whose records failed to save. OrphanGrace spares
+// those of downloads in progress.
+func (j *janitor) unreferenced() error {
+	if j.config.OrphanGrace <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-j.config.OrphanGrace).UTC().Format(time.DateTime)
+	objects, err := j.queryObjects(unreferencedObjectsQuery, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if !j.deleted[o.Key] {
+			j.deleteObject(ReasonOrphan, o)
+		}
+	}
+	return nil
+}
+
+// deleteObject removes a media object from the database and the storage,
+// unless a download used it meanwhile, and reports whether it did. Previews
+// pointing at it are cleared; rows referring to it by content hash are kept
+// as history.
+func (j *janitor) deleteObject(reason string, o mediaObject) bool {
+	j.deleted[o.Key] = true
+	action := Action{Reason: reason, Key: o.Key, Size: o.Size, LastUsed: o.LastUsed}
+	if !j.report.DryRun {
+		deleted, err := j.deleteRows(o)
+		if err == nil && !deleted {
+			log.Printf("Janitor kept %s, it was used again", o.Key)
+			return false
+		}
+		if err == nil {
+			err = j.deleteFile(o)
+		}
+		if err != nil {
+			action.Error = err.Error()
+		}
+	}
+	j.record(action)
+	return true
+}
+
+// deleteRows deletes the row of o if it wasn't used since it was read. The
+// file goes only once the row is gone, so a failure leaves an orphan rather
+// than a row without its file.
+func (j *janitor) deleteRows(o mediaObject) (bool, error) {
+	tx, err := j.db.BeginTx(j.ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(deleteObjectQuery, o.Hash, o.LastUsed.UTC().Format("2006-01-02 15:04:05.999999"))
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	for _, q := range []string{clearProcessedURLPreview, clearDownloadPreview} {
+		if _, err := tx.Exec(q, o.Key); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// deleteFile deletes the stored file of o, unless a download stored the same
+// content again since its row was deleted.
+func (j *janitor) deleteFile(o mediaObject) error {
+	var exists int
+	err := j.db.QueryRowContext(j.ctx, objectExistsQuery, o.Hash).Scan(&exists)
+	if err == nil {
+		log.Printf("Janitor kept %s, it was stored again", o.Key)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return j.st.Delete(j.ctx, o.Key)
+}
+
+// orphans deletes stored files no row refers to, and temporary objects
+// older than TmpAge.
+func (j *janitor) orphans() error {
+	now := time.Now()
+	return j.st.List(j.ctx, "", func(info storage.ObjectInfo) error {
+		if j.deleted[info.Key] {
+			return nil
+		}
+		age := now.Sub(info.LastModified)
+
+		reason := ReasonOrphan
+		if strings.HasPrefix(info.Key, "tmp/") {
+			if age < j.config.TmpAge {
+				return nil
+			}
+			reason = ReasonTemporary
+		} else {
+			if age < j.config.OrphanGrace {
+				return nil
+			}
+			var referenced int
+			err := j.db.QueryRowContext(j.ctx, referencedKeyQuery, info.Key, info.Key, info.Key).Scan(&referenced)
+			if err == nil {
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		action := Action{Reason: reason, Key: info.Key, Size: info.Size, LastUsed: info.LastModified}
+		if !j.report.DryRun {
+			if err := j.st.Delete(j.ctx, info.Key); err != nil {
+				action.Error = err.Error()
+			}
+		}
+		j.record(action)
+		return nil
+	})
+}
+
+// localTmp deletes files left in LocalTmpDir by downloads that crashed
+// before cleaning up.
+func (j *janitor) localTmp() error {
+	if j.config.LocalTmpDir == "" || j.config.TmpAge <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(j.config.LocalTmpDir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < j.config.TmpAge {
+			continue
+		}
+		path := filepath.Join(j.config.LocalTmpDir, entry.Name())
+		action := Action{Reason: ReasonLocalTmp, Key: path, Size: info.Size(), LastUsed: info.ModTime()}
+		if !j.report.DryRun {
+			if err := os.Remove(path); err != nil {
+				action.Error = err.Error()
+			}
+		}
+		j.record(action)
+	}
+	return nil
+}
+
+func (j *janitor) record(action Action) {
+	if action.Error != "" {
+		log.Printf("Janitor failed to delete %s: %s", action.Key, action.Error)
+		j.report.Errors++
+	} else {
+		j.report.DeletedBytes += action.Size
+	}
+	j.report.Actions = append(j.report.Actions, action)
+}
+
+// Save stores the report in janitor_runs.
+func Save(db *repository.DB, report *Report) error {
+	details, err := json.Marshal(report.Actions)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(insertRunQuery,
+		report.StartedAt.UTC().Format(time.DateTime), report.FinishedAt.UTC().Format(time.DateTime),
+		report.Trigger, report.DryRun, len(report.Actions), report.DeletedBytes, report.Errors, string(details))
+	return err
+}
+
+// Schedule runs the janitor every interval until ctx is done.
+func Schedule(ctx context.Context, db *repository.DB, st storage.Storage, config Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := Run(ctx, db, st, config, "schedule", false)
+		if err != nil {
+			log.Printf("Janitor run failed: %v", err)
+		}
+		if err := Save(db, report); err != nil {
+			log.Printf("Failed to save janitor report: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
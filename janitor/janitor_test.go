@@ -0,0 +1,71 @@
+package janitor
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/storage"
+)
+
+// TestDeleteObjectUsedAgain checks that an object a download used after the
+// janitor read it keeps both its row and its file.
+func TestDeleteObjectUsedAgain(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	repo, err := repository.Open(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if err := migrations.Up(repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	st := storage.NewLocal(t.TempDir(), "")
+	if err := st.Put(ctx, "objects/h1.mp4", strings.NewReader("video"), 5, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveMediaObject("h1", "objects/h1.mp4", 5); err != nil {
+		t.Fatal(err)
+	}
+	db := repo.DB()
+	if _, err := db.Exec(`UPDATE media_objects SET last_used_at = '2020-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &janitor{ctx: ctx, db: db, st: st, report: &Report{}, deleted: map[string]bool{}}
+	objects, err := j.queryObjects(lruObjectsQuery)
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("got objects %v: %v", objects, err)
+	}
+	// A download of the same content marks the object as used.
+	if err := repo.SaveMediaObject("h1", "objects/h1.mp4", 5); err != nil {
+		t.Fatal(err)
+	}
+	if j.deleteObject(ReasonExpired, objects[0]) {
+		t.Error("deleted an object used again")
+	}
+	if _, err := repo.MediaObjectKey("h1"); err != nil {
+		t.Errorf("row of the object: %v", err)
+	}
+	r, err := st.Get(ctx, "objects/h1.mp4")
+	if err != nil {
+		t.Fatalf("file of the object: %v", err)
+	}
+	r.Close()
+
+	objects, err = j.queryObjects(lruObjectsQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.deleteObject(ReasonExpired, objects[0]) || j.report.Errors != 0 {
+		t.Fatalf("failed to delete an unused object: %+v", j.report.Actions)
+	}
+	if _, err := st.Get(ctx, "objects/h1.mp4"); err != storage.ErrNotFound {
+		t.Errorf("file of the deleted object: %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+// Package migrations versions the schema of the shared database. Migrations
+// are SQL files embedded from sql/<dialect>/, named <version>_<name>.up.sql
+// with a matching .down.sql, and applied in order of version. Each dialect
+// has its own files; a version means the same schema in both, though a
+// dialect may skip versions it doesn't need. An up migration starting with a
+// "-- requires: <feature>" line is left pending on databases without the
+// feature, such as SQLite built without FTS5 (the sqlite_fts5 build tag of
+// go-sqlite3), and applied once one with it runs the migrations. The
+// downloader owns the schema and applies them at startup; the other services
+// wait for it.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+//go:embed sql/sqlite/*.sql sql/postgres/*.sql
+var files embed.FS
+
+var (
+	createMigrationsTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	appliedVersionsQuery = `SELECT version FROM schema_migrations ORDER BY version`
+	currentVersionQuery  = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+	insertMigrationQuery = `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`
+	deleteMigrationQuery = `DELETE FROM schema_migrations WHERE version = ?`
+	tableExistsQueries   = map[repository.Dialect]string{
+		repository.SQLite:   `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		repository.Postgres: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`,
+	}
+	columnExistsQuery = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	// featureQueries tell whether the database supports a feature a
+	// migration requires.
+	featureQueries = map[repository.Dialect]map[string]string{
+		repository.SQLite: {
+			"fts5": `SELECT sqlite_compileoption_used('ENABLE_FTS5')`,
+		},
+	}
+)
+
+// requiresPrefix starts the first line of an up migration naming the feature
+// it requires.
+const requiresPrefix = "-- requires: "
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Requires is the feature the migration needs, if any.
+	Requires string
+}
+
+// All returns the embedded migrations of dialect ordered by version.
+func All(dialect repository.Dialect) ([]Migration, error) {
+	dir := "sql/" + dialectDir(dialect)
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: no migrations for %s", dialect)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrations: unexpected file %s", name)
+		}
+		number, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrations: invalid version in %s", name)
+		}
+		content, err := files.ReadFile(dir + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migrations: version %d is used by %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			if line, _, _ := strings.Cut(m.Up, "\n"); strings.HasPrefix(line, requiresPrefix) {
+				m.Requires = strings.TrimSpace(strings.TrimPrefix(line, requiresPrefix))
+			}
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrations: %d_%s has no up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the version the embedded migrations of dialect bring the
+// schema to.
+func Latest(dialect repository.Dialect) (int, error) {
+	migrations, err := All(dialect)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Up applies the migrations that haven't been applied yet, each in its own
+// transaction. Migrations requiring a feature the database lacks are
+// skipped and stay pending.
+func Up(db *repository.DB) error {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return err
+	}
+	if err := upgradeLegacy(db); err != nil {
+		return fmt.Errorf("migrations: failed to upgrade legacy schema: %w", err)
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if m.Requires != "" {
+			ok, err := supports(db, m.Requires)
+			if err != nil {
+				return fmt.Errorf("migrations: failed to check for %s: %w", m.Requires, err)
+			}
+			if !ok {
+				log.Printf("Skipping migration %d_%s, the database has no %s", m.Version, m.Name, m.Requires)
+				continue
+			}
+		}
+		log.Printf("Applying migration %d_%s", m.Version, m.Name)
+		err := inTx(db, func(tx *repository.Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(insertMigrationQuery, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migrations: %d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func Down(db *repository.DB, steps int) error {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migrations: %d_%s can't be reverted", m.Version, m.Name)
+		}
+		log.Printf("Reverting migration %d_%s", m.Version, m.Name)
+		err := inTx(db, func(tx *repository.Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(deleteMigrationQuery, m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migrations: reverting %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// Version returns the newest applied migration, 0 when there is none.
+func Version(db *repository.DB) (int, error) {
+	exists, err := tableExists(db, "schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow(currentVersionQuery).Scan(&version)
+	return version, err
+}
+
+// Wait blocks until the schema owner has applied all embedded migrations, so
+// services starting next to it don't query tables that don't exist yet.
+func Wait(ctx context.Context, db *repository.DB) error {
+	latest, err := Latest(db.Dialect)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		version, err := Version(db)
+		if err != nil {
+			return err
+		}
+		if version >= latest {
+			return nil
+		}
+		log.Printf("Waiting for database migrations (at version %d of %d)", version, latest)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func appliedVersions(db *repository.DB) (map[int]bool, error) {
+	if _, err := db.Exec(createMigrationsTableQuery); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(appliedVersionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func inTx(db *repository.DB, fn func(tx *repository.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Columns databases created before migrations may lack: they were added with
+// ALTER TABLE, which the initial migration's CREATE TABLE IF NOT EXISTS
+// doesn't do.
+var legacyColumns = []struct {
+	Table, Column, Definition string
+}{
+	{"processed_urls", "platform", "TEXT"},
+	{"processed_urls", "uploader", "TEXT"},
+	{"downloads", "platform", "TEXT"},
+	{"downloads", "uploader", "TEXT"},
+	{"processed_urls", "content_hash", "TEXT"},
+	{"downloads", "content_hash", "TEXT"},
+}
+
+// upgradeLegacy brings the tables of a database created before migrations up
+// to the initial migration. It does nothing once schema_migrations exists,
+// nor on PostgreSQL, which was only supported with migrations.
+func upgradeLegacy(db *repository.DB) error {
+	if db.Dialect != repository.SQLite {
+		return nil
+	}
+	exists, err := tableExists(db, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	for _, c := range legacyColumns {
+		exists, err := tableExists(db, c.Table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		var count int
+		err = db.QueryRow(columnExistsQuery, c.Table, c.Column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		log.Printf("Adding column %s.%s", c.Table, c.Column)
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.Table, c.Column, c.Definition))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supports reports whether db has feature, by one of featureQueries.
+func supports(db *repository.DB, feature string) (bool, error) {
+	query, ok := featureQueries[db.Dialect][feature]
+	if !ok {
+		return false, fmt.Errorf("unknown feature %q for %s", feature, db.Dialect)
+	}
+	var supported bool
+	err := db.QueryRow(query).Scan(&supported)
+	return supported, err
+}
+
+func tableExists(db *repository.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(tableExistsQueries[db.Dialect], table).Scan(&count)
+	return count > 0, err
+}
+
+// dialectDir returns the directory under sql/ holding the migrations of
+// dialect.
+func dialectDir(dialect repository.Dialect) string {
+	if dialect == repository.SQLite {
+		return "sqlite"
+	}
+	return string(dialect)
+}
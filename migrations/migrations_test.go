@@ -0,0 +1,306 @@
+package migrations
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instaVideoDownloaderBot/repository"
+)
+
+// baselineSchema is the database the downloader created before it had
+// migrations or any of the columns added since.
+const baselineSchema = `
+CREATE TABLE processed_urls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT
+);
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	total_bytes_downloaded INTEGER DEFAULT 0
+);
+CREATE TABLE downloads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+INSERT INTO users (user_id, username, total_bytes_downloaded) VALUES (42, 'old', 100);
+INSERT INTO users (user_id, username, total_bytes_downloaded) VALUES (42, 'new', 50);
+INSERT INTO processed_urls (url, file_size) VALUES ('https://www.instagram.com/p/abc/', 100);
+INSERT INTO downloads (user_id, url, file_size) VALUES (42, 'https://www.instagram.com/p/abc/', 100);
+`
+
+func openSQLite(t *testing.T) *repository.DB {
+	t.Helper()
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openPostgres opens a fresh schema of the PostgreSQL database at
+// TEST_DATABASE_URL, skipping the test when it isn't set. The schema is
+// dropped afterwards.
+func openPostgres(t *testing.T) *repository.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := repository.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Logf("Failed to drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := repository.OpenPostgres(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// eachDatabase runs test against a fresh SQLite database and, when
+// TEST_DATABASE_URL is set, a fresh PostgreSQL schema.
+func eachDatabase(t *testing.T, test func(t *testing.T, db *repository.DB)) {
+	t.Run("sqlite", func(t *testing.T) { test(t, openSQLite(t)) })
+	t.Run("postgres", func(t *testing.T) { test(t, openPostgres(t)) })
+}
+
+// up applies the migrations. Without FTS5 the search migration stays
+// pending, which the version checks don't see: later versions are applied.
+func up(t *testing.T, db *repository.DB) {
+	t.Helper()
+	if err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkVersion(t *testing.T, db *repository.DB, want int) {
+	t.Helper()
+	version, err := Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Fatalf("at version %d, want %d", version, want)
+	}
+}
+
+var tablesQueries = map[repository.Dialect]string{
+	repository.SQLite: `SELECT name FROM sqlite_master WHERE type = 'table'
+		AND name NOT LIKE 'sqlite_%' ORDER BY name`,
+	repository.Postgres: `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() ORDER BY table_name`,
+}
+
+// tables returns the tables of db other than the internal ones of SQLite.
+func tables(t *testing.T, db *repository.DB) []string {
+	t.Helper()
+	rows, err := db.Query(tablesQueries[db.Dialect])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestAll(t *testing.T) {
+	for _, dialect := range []repository.Dialect{repository.SQLite, repository.Postgres} {
+		migrations, err := All(dialect)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		for i, m := range migrations {
+			if m.Down == "" {
+				t.Errorf("%s: %d_%s has no down migration", dialect, m.Version, m.Name)
+			}
+			if i > 0 && m.Version <= migrations[i-1].Version {
+				t.Errorf("%s: %d_%s is out of order", dialect, m.Version, m.Name)
+			}
+		}
+	}
+
+	// Both dialects have the same versions, except for those only one of
+	// them needs.
+	onlyIn := map[repository.Dialect]map[int]bool{
+		// PostgreSQL starts with users keyed by their Telegram ID.
+		repository.SQLite:   {3: true},
+		repository.Postgres: {},
+	}
+	versions := map[int][]repository.Dialect{}
+	for dialect := range onlyIn {
+		migrations, _ := All(dialect)
+		for _, m := range migrations {
+			versions[m.Version] = append(versions[m.Version], dialect)
+		}
+	}
+	for version, dialects := range versions {
+		if len(dialects) == 1 && !onlyIn[dialects[0]][version] {
+			t.Errorf("migration %d exists only for %s", version, dialects[0])
+		}
+		if len(dialects) == 2 && (onlyIn[dialects[0]][version] || onlyIn[dialects[1]][version]) {
+			t.Errorf("migration %d exists for both dialects", version)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	eachDatabase(t, testUpDown)
+}
+
+func testUpDown(t *testing.T, db *repository.DB) {
+	latest, err := Latest(db.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, db, 0)
+
+	up(t, db)
+	checkVersion(t, db, latest)
+	migrated := tables(t, db)
+
+	// Applying them again changes nothing.
+	up(t, db)
+	checkVersion(t, db, latest)
+
+	if err := Down(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	all, _ := All(db.Dialect)
+	checkVersion(t, db, all[len(all)-2].Version)
+	up(t, db)
+	checkVersion(t, db, latest)
+
+	if err := Down(db, len(all)); err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, db, 0)
+	if left := tables(t, db); len(left) != 1 || left[0] != "schema_migrations" {
+		t.Errorf("reverting every migration left tables %v", left)
+	}
+
+	up(t, db)
+	checkVersion(t, db, latest)
+	if got := tables(t, db); strings.Join(got, ",") != strings.Join(migrated, ",") {
+		t.Errorf("got tables %v after migrating again, want %v", got, migrated)
+	}
+}
+
+func TestUpgradeBaseline(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	up(t, db)
+	latest, _ := Latest(db.Dialect)
+	checkVersion(t, db, latest)
+
+	// The duplicate users are merged into the latest profile with the full
+	// byte count, and the download still belongs to it.
+	var username string
+	var users, total int64
+	err := db.QueryRow(`SELECT COUNT(*), MAX(username), MAX(total_bytes_downloaded) FROM users WHERE user_id = 42`).Scan(&users, &username, &total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users != 1 || username != "new" || total != 100 {
+		t.Errorf("got %d users, last named %q with %d bytes", users, username, total)
+	}
+
+	var url, tier string
+	err = db.QueryRow(`SELECT d.url, u.tier FROM downloads d JOIN users u ON u.user_id = d.user_id
+		WHERE d.platform IS NULL AND d.content_hash IS NULL`).Scan(&url, &tier)
+	if err != nil {
+		t.Fatalf("download didn't survive the upgrade: %v", err)
+	}
+	if tier != "free" {
+		t.Errorf("got tier %q, want free", tier)
+	}
+
+	var uploader *string
+	err = db.QueryRow(`SELECT uploader FROM processed_urls WHERE url = ?`, url).Scan(&uploader)
+	if err != nil || uploader != nil {
+		t.Errorf("processed URL: uploader %v, %v", uploader, err)
+	}
+}
+
+// TestRequires checks that the search migration is applied exactly when
+// SQLite has FTS5, and stays pending otherwise.
+func TestRequires(t *testing.T) {
+	db := openSQLite(t)
+	all, err := All(db.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var search Migration
+	for _, m := range all {
+		if m.Name == "search" {
+			search = m
+		}
+	}
+	if search.Requires != "fts5" {
+		t.Fatalf("search migration requires %q, want fts5", search.Requires)
+	}
+
+	up(t, db)
+	fts5, err := supports(db, "fts5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied[search.Version] != fts5 {
+		t.Errorf("search migration applied: %t, SQLite has FTS5: %t", applied[search.Version], fts5)
+	}
+}
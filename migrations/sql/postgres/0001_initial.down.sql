@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS seen_posts;
+DROP TABLE IF EXISTS followed_profiles;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS user_settings;
+DROP TABLE IF EXISTS blocked_users;
+DROP TABLE IF EXISTS broadcast_deliveries;
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS proxy_stats;
+DROP TABLE IF EXISTS janitor_runs;
+DROP TABLE IF EXISTS media_objects;
+DROP TABLE IF EXISTS cookie_accounts;
+DROP TABLE IF EXISTS failed_downloads;
+DROP TABLE IF EXISTS story_items;
+DROP TABLE IF EXISTS downloads;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS processed_urls;
@@ -0,0 +1,144 @@
+-- The schema as of SQLite migration 3: PostgreSQL databases start with
+-- users keyed by their Telegram ID, so that migration has no counterpart
+-- here. Telegram IDs and chat IDs don't fit in INTEGER, hence BIGINT.
+CREATE TABLE IF NOT EXISTS processed_urls (
+	id BIGSERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	file_size BIGINT,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT
+);
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL UNIQUE,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	total_bytes_downloaded BIGINT DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS downloads (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (user_id),
+	url TEXT NOT NULL,
+	timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	file_size BIGINT,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT
+);
+CREATE TABLE IF NOT EXISTS story_items (
+	id BIGSERIAL PRIMARY KEY,
+	story_id TEXT NOT NULL,
+	owner TEXT,
+	taken_at BIGINT,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (story_id, chat_id)
+);
+CREATE TABLE IF NOT EXISTS failed_downloads (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	url TEXT NOT NULL,
+	platform TEXT,
+	mode TEXT,
+	error_class TEXT,
+	error TEXT,
+	timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS cookie_accounts (
+	platform TEXT NOT NULL,
+	name TEXT NOT NULL,
+	healthy BOOLEAN NOT NULL DEFAULT TRUE,
+	last_used_at TIMESTAMP,
+	last_error TEXT,
+	checked_at TIMESTAMP,
+	PRIMARY KEY (platform, name)
+);
+CREATE TABLE IF NOT EXISTS media_objects (
+	hash TEXT PRIMARY KEY,
+	key TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS janitor_runs (
+	id BIGSERIAL PRIMARY KEY,
+	started_at TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP,
+	trigger TEXT NOT NULL,
+	dry_run BOOLEAN NOT NULL,
+	deleted_count INTEGER NOT NULL DEFAULT 0,
+	deleted_bytes BIGINT NOT NULL DEFAULT 0,
+	error_count INTEGER NOT NULL DEFAULT 0,
+	details TEXT
+);
+CREATE TABLE IF NOT EXISTS proxy_stats (
+	proxy TEXT PRIMARY KEY,
+	successes INTEGER NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0,
+	consecutive_failures INTEGER NOT NULL DEFAULT 0,
+	banned_until TIMESTAMP,
+	last_error TEXT,
+	last_used_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS broadcasts (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL DEFAULT 'text',
+	text TEXT,
+	media TEXT,
+	segment TEXT NOT NULL DEFAULT 'all',
+	segment_days INTEGER DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+	broadcast_id BIGINT NOT NULL REFERENCES broadcasts (id),
+	user_id BIGINT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER DEFAULT 0,
+	error TEXT,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (broadcast_id, user_id)
+);
+CREATE TABLE IF NOT EXISTS blocked_users (
+	user_id BIGINT PRIMARY KEY,
+	blocked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS user_settings (
+	user_id BIGINT PRIMARY KEY,
+	language TEXT
+);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	username TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (chat_id, username)
+);
+CREATE TABLE IF NOT EXISTS followed_profiles (
+	username TEXT PRIMARY KEY,
+	seeded INTEGER DEFAULT 0,
+	failures INTEGER DEFAULT 0,
+	last_polled_at TIMESTAMP,
+	backoff_until TIMESTAMP,
+	last_error TEXT
+);
+CREATE TABLE IF NOT EXISTS seen_posts (
+	username TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (username, post_id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_processed_urls_url;
+DROP INDEX IF EXISTS idx_downloads_url;
+DROP INDEX IF EXISTS idx_downloads_user_id;
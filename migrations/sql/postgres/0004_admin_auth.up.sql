@@ -0,0 +1,20 @@
+-- Accounts of the admin panel. password_hash is a bcrypt hash, empty for
+-- accounts that only log in with Telegram.
+CREATE TABLE admin_accounts (
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL DEFAULT '',
+	role TEXT NOT NULL DEFAULT 'viewer',
+	telegram_id BIGINT UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- id is the SHA-256 of the session cookie, so a leaked database doesn't
+-- hand out live sessions.
+CREATE TABLE admin_sessions (
+	id TEXT PRIMARY KEY,
+	account_id BIGINT NOT NULL REFERENCES admin_accounts (id) ON DELETE CASCADE,
+	csrf_token TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_admin_sessions_account_id ON admin_sessions (account_id);
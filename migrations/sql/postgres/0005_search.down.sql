@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_processed_urls_search;
+ALTER TABLE processed_urls DROP COLUMN IF EXISTS search;
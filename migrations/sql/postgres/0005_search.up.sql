@@ -0,0 +1,9 @@
+-- Full-text index of processed URLs. The generated column keeps it in sync.
+-- The simple configuration doesn't stem, since descriptions come in any
+-- language; tags and uploaders rank above descriptions.
+ALTER TABLE processed_urls ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(tags, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(uploader, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+CREATE INDEX idx_processed_urls_search ON processed_urls USING GIN (search);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_downloads_user_id_timestamp;
+ALTER TABLE users DROP COLUMN ban_reason;
+ALTER TABLE users DROP COLUMN banned_at;
+ALTER TABLE users DROP COLUMN tier;
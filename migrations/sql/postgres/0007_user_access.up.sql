@@ -0,0 +1,7 @@
+-- tier sets the daily download limits of a user; see the quota package.
+-- banned_at is set while an admin has banned the user from the bot.
+ALTER TABLE users ADD COLUMN tier TEXT NOT NULL DEFAULT 'free';
+ALTER TABLE users ADD COLUMN banned_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN ban_reason TEXT;
+-- The quota check counts a user's recent downloads.
+CREATE INDEX IF NOT EXISTS idx_downloads_user_id_timestamp ON downloads (user_id, timestamp);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS processed_urls_content_hash;
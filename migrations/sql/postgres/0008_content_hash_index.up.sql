@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS processed_urls_content_hash ON processed_urls (content_hash);
@@ -0,0 +1,145 @@
+-- The schema as it was when migrations were introduced. IF NOT EXISTS lets
+-- databases created before that adopt it; see upgradeLegacy.
+CREATE TABLE IF NOT EXISTS processed_urls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT
+);
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	total_bytes_downloaded INTEGER DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS downloads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE TABLE IF NOT EXISTS story_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	story_id TEXT NOT NULL,
+	owner TEXT,
+	taken_at INTEGER,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (story_id, chat_id)
+);
+CREATE TABLE IF NOT EXISTS failed_downloads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	platform TEXT,
+	mode TEXT,
+	error_class TEXT,
+	error TEXT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS cookie_accounts (
+	platform TEXT NOT NULL,
+	name TEXT NOT NULL,
+	healthy BOOLEAN NOT NULL DEFAULT 1,
+	last_used_at DATETIME,
+	last_error TEXT,
+	checked_at DATETIME,
+	PRIMARY KEY (platform, name)
+);
+CREATE TABLE IF NOT EXISTS media_objects (
+	hash TEXT PRIMARY KEY,
+	key TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS janitor_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	trigger TEXT NOT NULL,
+	dry_run BOOLEAN NOT NULL,
+	deleted_count INTEGER NOT NULL DEFAULT 0,
+	deleted_bytes INTEGER NOT NULL DEFAULT 0,
+	error_count INTEGER NOT NULL DEFAULT 0,
+	details TEXT
+);
+CREATE TABLE IF NOT EXISTS proxy_stats (
+	proxy TEXT PRIMARY KEY,
+	successes INTEGER NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0,
+	consecutive_failures INTEGER NOT NULL DEFAULT 0,
+	banned_until DATETIME,
+	last_error TEXT,
+	last_used_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS broadcasts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL DEFAULT 'text',
+	text TEXT,
+	media TEXT,
+	segment TEXT NOT NULL DEFAULT 'all',
+	segment_days INTEGER DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	started_at DATETIME,
+	finished_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+	broadcast_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER DEFAULT 0,
+	error TEXT,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (broadcast_id, user_id),
+	FOREIGN KEY (broadcast_id) REFERENCES broadcasts (id)
+);
+CREATE TABLE IF NOT EXISTS blocked_users (
+	user_id INTEGER PRIMARY KEY,
+	blocked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS user_settings (
+	user_id INTEGER PRIMARY KEY,
+	language TEXT
+);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (chat_id, username)
+);
+CREATE TABLE IF NOT EXISTS followed_profiles (
+	username TEXT PRIMARY KEY,
+	seeded INTEGER DEFAULT 0,
+	failures INTEGER DEFAULT 0,
+	last_polled_at DATETIME,
+	backoff_until DATETIME,
+	last_error TEXT
+);
+CREATE TABLE IF NOT EXISTS seen_posts (
+	username TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (username, post_id)
+);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_downloads_user_id ON downloads (user_id);
+CREATE INDEX IF NOT EXISTS idx_downloads_url ON downloads (url);
+CREATE INDEX IF NOT EXISTS idx_processed_urls_url ON processed_urls (url);
@@ -0,0 +1,34 @@
+-- The duplicate users merged by the up migration are not restored.
+CREATE TABLE downloads_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+INSERT INTO downloads_old (id, user_id, url, timestamp, file_size, preview_image, tags, description, platform, uploader, content_hash)
+SELECT id, user_id, url, timestamp, file_size, preview_image, tags, description, platform, uploader, content_hash
+FROM downloads;
+DROP TABLE downloads;
+ALTER TABLE downloads_old RENAME TO downloads;
+CREATE INDEX idx_downloads_user_id ON downloads (user_id);
+CREATE INDEX idx_downloads_url ON downloads (url);
+
+CREATE TABLE users_old (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	total_bytes_downloaded INTEGER DEFAULT 0
+);
+INSERT INTO users_old SELECT id, user_id, username, first_name, last_name, total_bytes_downloaded FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
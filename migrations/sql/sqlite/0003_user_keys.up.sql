@@ -0,0 +1,51 @@
+-- users.user_id is the Telegram user ID, which everything else refers to.
+-- It had no UNIQUE constraint, so every task added another copy of the
+-- user; keep the latest profile with the highest byte count (each copy was
+-- counted from its creation, so the oldest one holds the full total).
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL UNIQUE,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	total_bytes_downloaded INTEGER DEFAULT 0
+);
+INSERT INTO users_new (id, user_id, username, first_name, last_name, total_bytes_downloaded)
+SELECT u.id, u.user_id, u.username, u.first_name, u.last_name, latest.total
+FROM users u
+JOIN (
+	SELECT MAX(id) AS id, MAX(total_bytes_downloaded) AS total
+	FROM users
+	GROUP BY user_id
+) latest ON latest.id = u.id;
+-- Downloads of users whose profile failed to save.
+INSERT INTO users_new (user_id, total_bytes_downloaded)
+SELECT user_id, COALESCE(SUM(file_size), 0)
+FROM downloads
+WHERE user_id NOT IN (SELECT user_id FROM users_new)
+GROUP BY user_id;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+-- downloads.user_id holds the Telegram user ID, but referenced users.id.
+CREATE TABLE downloads_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	file_size INTEGER,
+	preview_image TEXT,
+	tags TEXT,
+	description TEXT,
+	platform TEXT,
+	uploader TEXT,
+	content_hash TEXT,
+	FOREIGN KEY (user_id) REFERENCES users (user_id)
+);
+INSERT INTO downloads_new (id, user_id, url, timestamp, file_size, preview_image, tags, description, platform, uploader, content_hash)
+SELECT id, user_id, url, timestamp, file_size, preview_image, tags, description, platform, uploader, content_hash
+FROM downloads;
+DROP TABLE downloads;
+ALTER TABLE downloads_new RENAME TO downloads;
+CREATE INDEX idx_downloads_user_id ON downloads (user_id);
+CREATE INDEX idx_downloads_url ON downloads (url);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_admin_sessions_account_id;
+DROP TABLE IF EXISTS admin_sessions;
+DROP TABLE IF EXISTS admin_accounts;
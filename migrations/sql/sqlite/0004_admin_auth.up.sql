@@ -0,0 +1,21 @@
+-- Accounts of the admin panel. password_hash is a bcrypt hash, empty for
+-- accounts that only log in with Telegram.
+CREATE TABLE admin_accounts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL DEFAULT '',
+	role TEXT NOT NULL DEFAULT 'viewer',
+	telegram_id INTEGER UNIQUE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+-- id is the SHA-256 of the session cookie, so a leaked database doesn't
+-- hand out live sessions.
+CREATE TABLE admin_sessions (
+	id TEXT PRIMARY KEY,
+	account_id INTEGER NOT NULL,
+	csrf_token TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (account_id) REFERENCES admin_accounts (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_admin_sessions_account_id ON admin_sessions (account_id);
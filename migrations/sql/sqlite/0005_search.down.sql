@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS processed_urls_fts_update;
+DROP TRIGGER IF EXISTS processed_urls_fts_delete;
+DROP TRIGGER IF EXISTS processed_urls_fts_insert;
+DROP TABLE IF EXISTS processed_urls_fts;
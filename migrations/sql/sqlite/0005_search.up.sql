@@ -0,0 +1,26 @@
+-- requires: fts5
+-- Full-text index of processed URLs. It is an external content table, so
+-- the triggers keep it in sync with processed_urls. Needs a SQLite built
+-- with FTS5, which go-sqlite3 only includes with the sqlite_fts5 build tag;
+-- without it the migration stays pending and search is unavailable.
+CREATE VIRTUAL TABLE processed_urls_fts USING fts5(
+	description, tags, uploader,
+	content = 'processed_urls', content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+INSERT INTO processed_urls_fts (processed_urls_fts) VALUES ('rebuild');
+
+CREATE TRIGGER processed_urls_fts_insert AFTER INSERT ON processed_urls BEGIN
+	INSERT INTO processed_urls_fts (rowid, description, tags, uploader)
+	VALUES (new.id, new.description, new.tags, new.uploader);
+END;
+CREATE TRIGGER processed_urls_fts_delete AFTER DELETE ON processed_urls BEGIN
+	INSERT INTO processed_urls_fts (processed_urls_fts, rowid, description, tags, uploader)
+	VALUES ('delete', old.id, old.description, old.tags, old.uploader);
+END;
+CREATE TRIGGER processed_urls_fts_update AFTER UPDATE OF description, tags, uploader ON processed_urls BEGIN
+	INSERT INTO processed_urls_fts (processed_urls_fts, rowid, description, tags, uploader)
+	VALUES ('delete', old.id, old.description, old.tags, old.uploader);
+	INSERT INTO processed_urls_fts (rowid, description, tags, uploader)
+	VALUES (new.id, new.description, new.tags, new.uploader);
+END;
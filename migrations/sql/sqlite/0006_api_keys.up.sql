@@ -0,0 +1,9 @@
+-- Keys of the /api/v1 JSON API. key_hash is the SHA-256 of the key, which
+-- is only shown when it is created.
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME
+);
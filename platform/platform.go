@@ -0,0 +1,228 @@
+// Package platform describes the video sites the bot can download from: how
+// their links look, which cookies and formats yt-dlp should use for them, how
+// captions are built and how download errors are classified.
+package platform
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// ErrorClass is a coarse reason of a failed download, shown to users and
+// aggregated in the admin.
+type ErrorClass string
+
+const (
+	ErrorUnknown       ErrorClass = "unknown"
+	ErrorLoginRequired ErrorClass = "login_required"
+	ErrorRateLimited   ErrorClass = "rate_limited"
+	ErrorPrivate       ErrorClass = "private"
+	ErrorNotFound      ErrorClass = "not_found"
+	ErrorUnsupported   ErrorClass = "unsupported"
+)
+
+// Substrings of lowercased yt-dlp output shared by all extractors. They are
+// checked after the platform specific ones, in this order.
+var commonErrors = []errorPattern{
+	{ErrorRateLimited, []string{"http error 429", "too many requests"}},
+	{ErrorLoginRequired, []string{"login required", "sign in to confirm", "use --cookies"}},
+	{ErrorPrivate, []string{"private"}},
+	{ErrorNotFound, []string{"http error 404", "not found", "does not exist", "has been removed", "video unavailable", "no video"}},
+	{ErrorUnsupported, []string{"unsupported url"}},
+}
+
+type errorPattern struct {
+	Class    ErrorClass
+	Patterns []string
+}
+
+// CaptionData is what caption templates are rendered with.
+type CaptionData struct {
+	Title       string
+	Description string
+	Uploader    string
+	URL         string
+}
+
+type Platform struct {
+	// Name is the stable identifier stored in the database.
+	Name string
+	// Title is the human-readable name shown to users.
+	Title string
+	// CookiesEnv is the environment variable holding the path of a Netscape
+	// cookies file for this platform. Cookies are optional except for
+	// platforms that require login.
+	CookiesEnv string
+	// CookiesDirEnv is the environment variable holding a directory of
+	// Netscape cookies files (*.txt), one per account. The downloader rotates
+	// between them.
+	CookiesDirEnv string
+	// Format is passed to yt-dlp as -f. The bot sends MP4, so formats that
+	// need no re-encoding are preferred.
+	Format string
+
+	patterns []*regexp.Regexp
+	caption  *template.Template
+	errors   []errorPattern
+}
+
+var registry = []*Platform{
+	{
+		Name:          "instagram",
+		Title:         "Instagram",
+		CookiesEnv:    "COOKIES_FILE_PATH",
+		CookiesDirEnv: "COOKIES_DIR",
+		Format:        "b[ext=mp4]/bv*+ba/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.)?(instagram\.com|instagr\.am)/([^/?#]+/)?(p|reel|reels|tv|stories)/`),
+		},
+		caption: template.Must(template.New("instagram").Parse(`{{.Description}}`)),
+		errors: []errorPattern{
+			{ErrorRateLimited, []string{"rate-limit reached", "please wait a few minutes"}},
+			{ErrorLoginRequired, []string{"checkpoint", "login_required", "login required"}},
+		},
+	},
+	{
+		Name:          "tiktok",
+		Title:         "TikTok",
+		CookiesEnv:    "TIKTOK_COOKIES_FILE_PATH",
+		CookiesDirEnv: "TIKTOK_COOKIES_DIR",
+		Format:        "b[ext=mp4]/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.|m\.)?tiktok\.com/@[^/]+/(video|photo)/\d+`),
+			regexp.MustCompile(`^https?://(vm|vt)\.tiktok\.com/\w+`),
+		},
+		caption: template.Must(template.New("tiktok").Parse(`{{.Description}}{{if .Uploader}}` + "\n" + `— @{{.Uploader}}{{end}}`)),
+	},
+	{
+		Name:          "youtube",
+		Title:         "YouTube Shorts",
+		CookiesEnv:    "YOUTUBE_COOKIES_FILE_PATH",
+		CookiesDirEnv: "YOUTUBE_COOKIES_DIR",
+		Format:        "bv*[height<=1080][ext=mp4]+ba[ext=m4a]/b[ext=mp4]/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.|m\.)?youtube\.com/shorts/[\w-]+`),
+			regexp.MustCompile(`^https?://youtu\.be/[\w-]+`),
+		},
+		caption: template.Must(template.New("youtube").Parse(`{{.Title}}{{if .Uploader}}` + "\n" + `— {{.Uploader}}{{end}}`)),
+		errors: []errorPattern{
+			{ErrorLoginRequired, []string{"confirm your age", "not a bot"}},
+		},
+	},
+	{
+		Name:          "twitter",
+		Title:         "Twitter / X",
+		CookiesEnv:    "TWITTER_COOKIES_FILE_PATH",
+		CookiesDirEnv: "TWITTER_COOKIES_DIR",
+		Format:        "b[ext=mp4]/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.|mobile\.)?(twitter|x)\.com/[^/]+/status/\d+`),
+		},
+		caption: template.Must(template.New("twitter").Parse(`{{.Description}}{{if .Uploader}}` + "\n" + `— {{.Uploader}}{{end}}`)),
+		errors: []errorPattern{
+			{ErrorPrivate, []string{"protected"}},
+			{ErrorNotFound, []string{"no video could be found"}},
+		},
+	},
+	{
+		Name:          "reddit",
+		Title:         "Reddit",
+		CookiesEnv:    "REDDIT_COOKIES_FILE_PATH",
+		CookiesDirEnv: "REDDIT_COOKIES_DIR",
+		Format:        "bv*+ba/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.|old\.|new\.)?reddit\.com/r/[^/]+/(comments|s)/\w+`),
+			regexp.MustCompile(`^https?://(v\.)?redd\.it/\w+`),
+		},
+		caption: template.Must(template.New("reddit").Parse(`{{.Title}}`)),
+	},
+	{
+		Name:          "vk",
+		Title:         "VK",
+		CookiesEnv:    "VK_COOKIES_FILE_PATH",
+		CookiesDirEnv: "VK_COOKIES_DIR",
+		Format:        "b[ext=mp4]/b",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`^https?://(www\.|m\.)?vk\.(com|ru)/(video|clip)-?\d+_\d+`),
+			regexp.MustCompile(`^https?://(www\.)?vkvideo\.ru/(video|clip)-?\d+_\d+`),
+		},
+		caption: template.Must(template.New("vk").Parse(`{{.Title}}`)),
+	},
+}
+
+// All returns every supported platform in display order.
+func All() []*Platform {
+	return registry
+}
+
+// Match returns the platform whose links look like url.
+func Match(url string) (*Platform, bool) {
+	for _, p := range registry {
+		for _, pattern := range p.patterns {
+			if pattern.MatchString(url) {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ByName returns the platform with the given name.
+func ByName(name string) (*Platform, bool) {
+	for _, p := range registry {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Titles returns the display names of all platforms.
+func Titles() []string {
+	titles := make([]string, len(registry))
+	for i, p := range registry {
+		titles[i] = p.Title
+	}
+	return titles
+}
+
+// CookiesFile returns the configured cookies file of the platform, or an
+// empty string when none is set.
+func (p *Platform) CookiesFile() string {
+	return os.Getenv(p.CookiesEnv)
+}
+
+// CookiesDir returns the configured directory of cookies files of the
+// platform, or an empty string when none is set.
+func (p *Platform) CookiesDir() string {
+	return os.Getenv(p.CookiesDirEnv)
+}
+
+// Caption renders the platform caption template.
+func (p *Platform) Caption(data CaptionData) string {
+	var buf bytes.Buffer
+	if err := p.caption.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render %s caption: %v", p.Name, err)
+		return data.Description
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// ClassifyError maps yt-dlp output of a failed download to an ErrorClass.
+func (p *Platform) ClassifyError(output string) ErrorClass {
+	output = strings.ToLower(output)
+	for _, patterns := range [][]errorPattern{p.errors, commonErrors} {
+		for _, ep := range patterns {
+			for _, s := range ep.Patterns {
+				if strings.Contains(output, s) {
+					return ep.Class
+				}
+			}
+		}
+	}
+	return ErrorUnknown
+}
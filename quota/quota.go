@@ -0,0 +1,90 @@
+// Package quota holds the download limits of the user tiers. The bot refuses
+// downloads past a user's limits and the admin shows how close users are to
+// them. Limits count the downloads of the last Window.
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The tiers a user can be in. New users are in TierFree.
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
+// Window is the period the limits apply to.
+const Window = 24 * time.Hour
+
+// Tier is the limits of a tier. Zero means no limit.
+type Tier struct {
+	Name         string
+	MaxDownloads int
+	MaxBytes     int64
+}
+
+// Usage is what a user downloaded in the last Window.
+type Usage struct {
+	Downloads int
+	Bytes     int64
+}
+
+// Exceeded reports whether another download would go past the limits.
+func (t Tier) Exceeded(u Usage) bool {
+	return (t.MaxDownloads > 0 && u.Downloads >= t.MaxDownloads) || (t.MaxBytes > 0 && u.Bytes >= t.MaxBytes)
+}
+
+// Tiers is the limits of every tier, in order.
+type Tiers []Tier
+
+// FromEnv reads the QUOTA_<TIER>_DOWNLOADS and QUOTA_<TIER>_MB variables,
+// the daily downloads and megabytes of each tier, with the getEnv of the
+// service. Unset, they don't limit.
+func FromEnv(getEnv func(key, fallback string) string) (Tiers, error) {
+	var tiers Tiers
+	for _, name := range []string{TierFree, TierPremium} {
+		tier := Tier{Name: name}
+		prefix := "QUOTA_" + strings.ToUpper(name)
+		var err error
+		tier.MaxDownloads, err = strconv.Atoi(getEnv(prefix+"_DOWNLOADS", "0"))
+		if err != nil || tier.MaxDownloads < 0 {
+			return nil, fmt.Errorf("invalid %s_DOWNLOADS: %q", prefix, getEnv(prefix+"_DOWNLOADS", "0"))
+		}
+		mb, err := strconv.ParseInt(getEnv(prefix+"_MB", "0"), 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid %s_MB: %q", prefix, getEnv(prefix+"_MB", "0"))
+		}
+		tier.MaxBytes = mb * 1024 * 1024
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// Get returns the tier with the name. Users in a tier that no longer
+// exists get the limits of TierFree.
+func (tiers Tiers) Get(name string) Tier {
+	for _, t := range tiers {
+		if t.Name == name {
+			return t
+		}
+	}
+	for _, t := range tiers {
+		if t.Name == TierFree {
+			return t
+		}
+	}
+	return Tier{Name: TierFree}
+}
+
+// Valid reports whether name is one of the tiers.
+func (tiers Tiers) Valid(name string) bool {
+	for _, t := range tiers {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	adminAccountColumns               = `id, username, password_hash, role, COALESCE(telegram_id, 0)`
+	selectAdminAccountByUsernameQuery = `SELECT ` + adminAccountColumns + ` FROM admin_accounts WHERE username = ?`
+	selectAdminAccountByTelegramQuery = `SELECT ` + adminAccountColumns + ` FROM admin_accounts WHERE telegram_id = ?`
+	upsertAdminAccountQuery           = `INSERT INTO admin_accounts (username, password_hash, role, telegram_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET password_hash = excluded.password_hash, role = excluded.role, telegram_id = excluded.telegram_id`
+	insertAdminSessionQuery = `INSERT INTO admin_sessions (id, account_id, csrf_token, expires_at) VALUES (?, ?, ?, ?)`
+	selectAdminSessionQuery = `SELECT s.id, s.csrf_token, s.expires_at,
+			a.id, a.username, a.password_hash, a.role, COALESCE(a.telegram_id, 0)
+		FROM admin_sessions s
+		JOIN admin_accounts a ON a.id = s.account_id
+		WHERE s.id = ? AND s.expires_at > ?`
+	deleteAdminSessionQuery         = `DELETE FROM admin_sessions WHERE id = ?`
+	deleteExpiredAdminSessionsQuery = `DELETE FROM admin_sessions WHERE expires_at <= ?`
+)
+
+// AdminAccount is an account of the admin panel. PasswordHash is a bcrypt
+// hash, empty when the account can only log in with Telegram; TelegramID is
+// 0 when it can't.
+type AdminAccount struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	Role         string
+	TelegramID   int64
+}
+
+// AdminSession is a logged in admin panel session. ID is the hash of the
+// session cookie, not the cookie itself.
+type AdminSession struct {
+	ID        string
+	Account   AdminAccount
+	CSRFToken string
+	ExpiresAt time.Time
+}
+
+func scanAdminAccount(r *Repository, query string, arg interface{}) (AdminAccount, error) {
+	var a AdminAccount
+	err := r.scanRow(query, []interface{}{arg}, &a.ID, &a.Username, &a.PasswordHash, &a.Role, &a.TelegramID)
+	return a, err
+}
+
+// AdminAccountByUsername returns the account, or ErrNotFound.
+func (r *Repository) AdminAccountByUsername(username string) (AdminAccount, error) {
+	return scanAdminAccount(r, selectAdminAccountByUsernameQuery, username)
+}
+
+// AdminAccountByTelegramID returns the account linked to the Telegram user,
+// or ErrNotFound.
+func (r *Repository) AdminAccountByTelegramID(telegramID int64) (AdminAccount, error) {
+	return scanAdminAccount(r, selectAdminAccountByTelegramQuery, telegramID)
+}
+
+// SaveAdminAccount creates the account, or replaces the password, role and
+// Telegram ID of the account with the same username.
+func (r *Repository) SaveAdminAccount(a AdminAccount) error {
+	var telegramID interface{}
+	if a.TelegramID != 0 {
+		telegramID = a.TelegramID
+	}
+	return r.exec(upsertAdminAccountQuery, a.Username, a.PasswordHash, a.Role, telegramID)
+}
+
+// CreateAdminSession stores a session of s.Account.
+func (r *Repository) CreateAdminSession(s AdminSession) error {
+	return r.exec(insertAdminSessionQuery, s.ID, s.Account.ID, s.CSRFToken, nullTime(s.ExpiresAt))
+}
+
+// AdminSession returns the session with its account, or ErrNotFound when it
+// doesn't exist or has expired.
+func (r *Repository) AdminSession(id string) (AdminSession, error) {
+	var s AdminSession
+	var expiresAt sql.NullTime
+	err := r.scanRow(selectAdminSessionQuery, []interface{}{id, nullTime(time.Now())},
+		&s.ID, &s.CSRFToken, &expiresAt,
+		&s.Account.ID, &s.Account.Username, &s.Account.PasswordHash, &s.Account.Role, &s.Account.TelegramID)
+	s.ExpiresAt = expiresAt.Time
+	return s, err
+}
+
+func (r *Repository) DeleteAdminSession(id string) error {
+	return r.exec(deleteAdminSessionQuery, id)
+}
+
+// DeleteExpiredAdminSessions removes the sessions that can't be used any
+// more.
+func (r *Repository) DeleteExpiredAdminSessions() error {
+	return r.exec(deleteExpiredAdminSessionsQuery, nullTime(time.Now()))
+}
@@ -0,0 +1,113 @@
+package repository
+
+import "database/sql"
+
+var (
+	listBroadcastsQuery = `
+		SELECT b.id, b.kind, COALESCE(b.text, ''), COALESCE(b.media, ''), b.segment, b.segment_days, b.status,
+			b.created_at, b.started_at, b.finished_at,
+			COUNT(CASE WHEN d.status = 'pending' THEN 1 END),
+			COUNT(CASE WHEN d.status = 'sent' THEN 1 END),
+			COUNT(CASE WHEN d.status = 'failed' THEN 1 END),
+			COUNT(CASE WHEN d.status = 'blocked' THEN 1 END)
+		FROM broadcasts b
+		LEFT JOIN broadcast_deliveries d ON d.broadcast_id = b.id
+		GROUP BY b.id
+		ORDER BY b.id DESC`
+	insertBroadcastQuery = `INSERT INTO broadcasts (kind, text, media, segment, segment_days) VALUES (?, ?, ?, ?, ?)`
+	listJanitorRunsQuery = `
+		SELECT id, started_at, finished_at, trigger, dry_run, deleted_count, deleted_bytes, error_count
+		FROM janitor_runs
+		ORDER BY id DESC
+		LIMIT ?`
+	selectJanitorRunDetailsQuery = `SELECT COALESCE(details, '[]') FROM janitor_runs WHERE id = ?`
+)
+
+// Broadcast is a broadcast with the number of deliveries in each status.
+// The bot sends it.
+type Broadcast struct {
+	ID          int64
+	Kind        string
+	Text        string
+	Media       string
+	Segment     string
+	SegmentDays int
+	Status      string
+	CreatedAt   string
+	StartedAt   string
+	FinishedAt  string
+	Pending     int
+	Sent        int
+	Failed      int
+	Blocked     int
+}
+
+func (r *Repository) ListBroadcasts() ([]Broadcast, error) {
+	rows, err := r.query(listBroadcastsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broadcasts []Broadcast
+	for rows.Next() {
+		var b Broadcast
+		var startedAt, finishedAt sql.NullString
+		err := rows.Scan(&b.ID, &b.Kind, &b.Text, &b.Media, &b.Segment, &b.SegmentDays, &b.Status, &b.CreatedAt, &startedAt, &finishedAt, &b.Pending, &b.Sent, &b.Failed, &b.Blocked)
+		if err != nil {
+			return nil, err
+		}
+		b.StartedAt, b.FinishedAt = startedAt.String, finishedAt.String
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, rows.Err()
+}
+
+// CreateBroadcast queues a broadcast. Only Kind, Text, Media, Segment and
+// SegmentDays are used.
+func (r *Repository) CreateBroadcast(b Broadcast) error {
+	return r.exec(insertBroadcastQuery, b.Kind, b.Text, b.Media, b.Segment, b.SegmentDays)
+}
+
+// JanitorRun is the summary of a janitor run. Its actions are returned by
+// JanitorRunDetails.
+type JanitorRun struct {
+	ID           int64
+	StartedAt    string
+	FinishedAt   string
+	Trigger      string
+	DryRun       bool
+	DeletedCount int
+	DeletedBytes int64
+	ErrorCount   int
+}
+
+// ListJanitorRuns returns the limit latest runs.
+func (r *Repository) ListJanitorRuns(limit int) ([]JanitorRun, error) {
+	rows, err := r.query(listJanitorRunsQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []JanitorRun
+	for rows.Next() {
+		var run JanitorRun
+		var finishedAt sql.NullString
+		err := rows.Scan(&run.ID, &run.StartedAt, &finishedAt, &run.Trigger, &run.DryRun, &run.DeletedCount, &run.DeletedBytes, &run.ErrorCount)
+		if err != nil {
+			return nil, err
+		}
+		run.FinishedAt = finishedAt.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// JanitorRunDetails returns the actions of a run as saved by janitor.Save, a
+// JSON array, or ErrNotFound.
+func (r *Repository) JanitorRunDetails(id int64) (string, error) {
+	var details string
+	err := r.scanRow(selectJanitorRunDetailsQuery, []interface{}{id}, &details)
+	return details, err
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	insertAPIKeyQuery       = `INSERT INTO api_keys (name, key_hash) VALUES (?, ?)`
+	selectAPIKeyByHashQuery = `SELECT id, name, created_at, last_used_at FROM api_keys WHERE key_hash = ?`
+	listAPIKeysQuery        = `SELECT id, name, created_at, last_used_at FROM api_keys ORDER BY name`
+	touchAPIKeyQuery        = `UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`
+	deleteAPIKeyQuery       = `DELETE FROM api_keys WHERE name = ?`
+)
+
+// apiKeyTouchInterval is how often the last use of a key is recorded, so
+// that busy scripts don't write to the database on every request.
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a key of the JSON API. The key itself isn't stored, only its
+// hash.
+type APIKey struct {
+	ID         int64
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+func scanAPIKey(scan func(dest ...interface{}) error) (APIKey, error) {
+	var k APIKey
+	var createdAt, lastUsedAt sql.NullTime
+	err := scan(&k.ID, &k.Name, &createdAt, &lastUsedAt)
+	k.CreatedAt, k.LastUsedAt = createdAt.Time, lastUsedAt.Time
+	return k, err
+}
+
+// CreateAPIKey stores a key under name, which must be unused.
+func (r *Repository) CreateAPIKey(name, keyHash string) error {
+	return r.exec(insertAPIKeyQuery, name, keyHash)
+}
+
+// APIKeyByHash returns the key with the hash, or ErrNotFound.
+func (r *Repository) APIKeyByHash(keyHash string) (APIKey, error) {
+	return scanAPIKey(func(dest ...interface{}) error {
+		return r.scanRow(selectAPIKeyByHashQuery, []interface{}{keyHash}, dest...)
+	})
+}
+
+func (r *Repository) ListAPIKeys() ([]APIKey, error) {
+	rows, err := r.query(listAPIKeysQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that the key was used, at most once a minute.
+func (r *Repository) TouchAPIKey(id int64) error {
+	now := time.Now()
+	return r.exec(touchAPIKeyQuery, nullTime(now), id, nullTime(now.Add(-apiKeyTouchInterval)))
+}
+
+// DeleteAPIKey revokes the key named name, or returns ErrNotFound.
+func (r *Repository) DeleteAPIKey(name string) error {
+	n, err := r.execCount(deleteAPIKeyQuery, name)
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
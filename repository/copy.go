@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// copiedTables are the tables CopyToPostgres moves, parents first, with
+// their columns: every table of the schema but the migrations' own and the
+// SQLite search index, which PostgreSQL computes.
+var copiedTables = []struct {
+	Name    string
+	Columns []string
+	// Serial is the column whose sequence continues after the copied ids.
+	Serial string
+}{
+	{"users", []string{"id", "user_id", "username", "first_name", "last_name", "total_bytes_downloaded", "tier", "banned_at", "ban_reason"}, "id"},
+	{"processed_urls", []string{"id", "url", "timestamp", "file_size", "preview_image", "tags", "description", "platform", "uploader", "content_hash"}, "id"},
+	{"downloads", []string{"id", "user_id", "url", "timestamp", "file_size", "preview_image", "tags", "description", "platform", "uploader", "content_hash"}, "id"},
+	{"media_objects", []string{"hash", "key", "size", "ref_count", "created_at", "last_used_at"}, ""},
+	{"subscriptions", []string{"id", "chat_id", "user_id", "username", "created_at"}, "id"},
+	{"followed_profiles", []string{"username", "seeded", "failures", "last_polled_at", "backoff_until", "last_error"}, ""},
+	{"seen_posts", []string{"username", "post_id", "seen_at"}, ""},
+	{"user_settings", []string{"user_id", "language"}, ""},
+	{"story_items", []string{"id", "story_id", "owner", "taken_at", "chat_id", "user_id", "timestamp"}, "id"},
+	{"failed_downloads", []string{"id", "user_id", "url", "platform", "mode", "error_class", "error", "timestamp"}, "id"},
+	{"broadcasts", []string{"id", "kind", "text", "media", "segment", "segment_days", "status", "created_at", "started_at", "finished_at"}, "id"},
+	{"broadcast_deliveries", []string{"broadcast_id", "user_id", "status", "attempts", "error", "updated_at"}, ""},
+	{"blocked_users", []string{"user_id", "blocked_at"}, ""},
+	{"cookie_accounts", []string{"platform", "name", "healthy", "last_used_at", "last_error", "checked_at"}, ""},
+	{"proxy_stats", []string{"proxy", "successes", "failures", "consecutive_failures", "banned_until", "last_error", "last_used_at"}, ""},
+	{"admin_accounts", []string{"id", "username", "password_hash", "role", "telegram_id", "created_at"}, "id"},
+	{"admin_sessions", []string{"id", "account_id", "csrf_token", "created_at", "expires_at"}, ""},
+	{"api_keys", []string{"id", "name", "key_hash", "created_at", "last_used_at"}, "id"},
+	{"janitor_runs", []string{"id", "started_at", "finished_at", "trigger", "dry_run", "deleted_count", "deleted_bytes", "error_count", "details"}, "id"},
+}
+
+// CopyToPostgres copies the rows of the SQLite database src to the
+// PostgreSQL database dst, keeping their ids. Both must be migrated to the
+// latest version. dst is written in one transaction and must not hold any
+// rows yet, so a failed copy can simply be run again.
+func CopyToPostgres(src, dst *DB) error {
+	if src.Dialect != SQLite || dst.Dialect != Postgres {
+		return fmt.Errorf("repository: can only copy from SQLite to PostgreSQL, not from %s to %s", src.Dialect, dst.Dialect)
+	}
+
+	tx, err := dst.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range copiedTables {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM " + table.Name).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("repository: %s already has %d rows in PostgreSQL", table.Name, count)
+		}
+	}
+
+	for _, table := range copiedTables {
+		copied, err := copyTable(src, tx, table.Name, table.Columns)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table.Name, err)
+		}
+		if table.Serial != "" {
+			_, err = tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+				table.Name, table.Serial, table.Serial, table.Name))
+			if err != nil {
+				return fmt.Errorf("failed to reset the %s sequence: %w", table.Name, err)
+			}
+		}
+		log.Printf("Copied %d rows of %s", copied, table.Name)
+	}
+	return tx.Commit()
+}
+
+func copyTable(src *DB, tx *Tx, table string, columns []string) (int, error) {
+	list := strings.Join(columns, ", ")
+	rows, err := src.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", list, table, columns[0]))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert, err := tx.Prepare(tx.db.Rebind(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, list, placeholders)))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	copied := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return copied, err
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, rows.Err()
+}
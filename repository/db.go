@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the name of a database/sql driver the schema is kept for.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// busyTimeout is how long, in milliseconds, a SQLite connection waits for
+// another service's write to finish before failing with "database is
+// locked".
+const busyTimeout = 5000
+
+// DB is a connection pool that accepts queries with ? placeholders in
+// either dialect. Queries must otherwise stick to SQL both understand.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// OpenDB opens the database at DATABASE_URL, a postgres:// URL, when it is
+// set, and the SQLite file at sqlitePath otherwise.
+func OpenDB(sqlitePath string) (*DB, error) {
+	url := os.Getenv("DATABASE_URL")
+	switch {
+	case url == "":
+		return OpenSQLite(sqlitePath)
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		return OpenPostgres(url)
+	default:
+		return nil, fmt.Errorf("unsupported DATABASE_URL scheme in %q", url)
+	}
+}
+
+// OpenSQLite opens the SQLite file at path in WAL mode, so readers don't
+// block the writer, with a busy timeout.
+func OpenSQLite(path string) (*DB, error) {
+	dsn := "file:" + path + "?_journal_mode=WAL&_busy_timeout=" + strconv.Itoa(busyTimeout)
+	return open(SQLite, dsn)
+}
+
+// OpenPostgres opens the PostgreSQL database at dsn, a postgres:// URL. Its
+// sessions use UTC, unless dsn sets another timezone, so CURRENT_TIMESTAMP
+// defaults compare with the times the repository stores, as they do in
+// SQLite.
+func OpenPostgres(dsn string) (*DB, error) {
+	dsn, err := postgresUTC(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return open(Postgres, dsn)
+}
+
+// postgresUTC adds timezone=UTC to dsn, a postgres:// URL, when it sets no
+// timezone. The driver passes it on as a session parameter.
+func postgresUTC(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid PostgreSQL URL: %w", err)
+	}
+	q := u.Query()
+	if q.Get("timezone") != "" {
+		return dsn, nil
+	}
+	q.Set("timezone", "UTC")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func open(dialect Dialect, dsn string) (*DB, error) {
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+// Rebind rewrites the ? placeholders of query to $1, $2... for PostgreSQL.
+// Question marks inside string literals are left alone.
+func (db *DB) Rebind(query string) string {
+	if db.Dialect != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Rebind(query), args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.Rebind(query))
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// Tx is a transaction of a DB, accepting ? placeholders like it.
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.Rebind(query), args...)
+}
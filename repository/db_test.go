@@ -0,0 +1,37 @@
+package repository
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	for _, test := range []struct {
+		query, want string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM users WHERE user_id = ?", "SELECT * FROM users WHERE user_id = $1"},
+		{"INSERT INTO t (a, b, c) VALUES (?, ?,?)", "INSERT INTO t (a, b, c) VALUES ($1, $2,$3)"},
+		{"SELECT '?', a FROM t WHERE b = ? AND c = 'it''s?'", "SELECT '?', a FROM t WHERE b = $1 AND c = 'it''s?'"},
+		{"UPDATE t SET a = 'Что?' WHERE b = ?", "UPDATE t SET a = 'Что?' WHERE b = $1"},
+	} {
+		postgres := &DB{Dialect: Postgres}
+		if got := postgres.Rebind(test.query); got != test.want {
+			t.Errorf("Rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+		sqlite := &DB{Dialect: SQLite}
+		if got := sqlite.Rebind(test.query); got != test.query {
+			t.Errorf("SQLite rewrote %q to %q", test.query, got)
+		}
+	}
+}
+
+func TestPostgresUTC(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"postgres://u:p@db:5432/videos":                   "postgres://u:p@db:5432/videos?timezone=UTC",
+		"postgres://u:p@db/videos?sslmode=disable":        "postgres://u:p@db/videos?sslmode=disable&timezone=UTC",
+		"postgresql://db/videos?timezone=Europe%2FMoscow": "postgresql://db/videos?timezone=Europe%2FMoscow",
+	} {
+		got, err := postgresUTC(dsn)
+		if err != nil || got != want {
+			t.Errorf("postgresUTC(%q) = %q, %v, want %q", dsn, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	upsertUserQuery = `INSERT INTO users (user_id, username, first_name, last_name) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET username = excluded.username, first_name = excluded.first_name, last_name = excluded.last_name`
+	addUserBytesQuery         = `UPDATE users SET total_bytes_downloaded = total_bytes_downloaded + ? WHERE user_id = ?`
+	insertDownloadQuery       = `INSERT INTO downloads (user_id, url, file_size, preview_image, tags, description, platform, uploader, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	insertProcessedURLQuery   = `INSERT INTO processed_urls (url, file_size, preview_image, tags, description, platform, uploader, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	insertFailedDownloadQuery = `INSERT INTO failed_downloads (user_id, url, platform, mode, error_class, error) VALUES (?, ?, ?, ?, ?, ?)`
+	insertStoryItemQuery      = `INSERT INTO story_items (story_id, owner, taken_at, chat_id, user_id) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	selectStoryItemQuery      = `SELECT 1 FROM story_items WHERE story_id = ? AND chat_id = ?`
+
+	// The list queries are completed with a WHERE clause, an ORDER BY
+	// clause and LIMIT ? OFFSET ?.
+	listProcessedURLsQuery = `
+		SELECT p.id, p.url, p.timestamp, COALESCE(p.file_size, 0), COALESCE(p.preview_image, ''), COALESCE(p.tags, ''), COALESCE(p.description, ''),
+			COALESCE(p.platform, ''), COALESCE(p.uploader, ''), COALESCE(p.content_hash, ''),
+			(SELECT COUNT(DISTINCT s.url) FROM processed_urls s WHERE s.content_hash = p.content_hash)
+		FROM processed_urls p`
+	countProcessedURLsQuery = `SELECT COUNT(*) FROM processed_urls p`
+	listDownloadsQuery      = `
+		SELECT d.id, u.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			d.url, d.timestamp, COALESCE(d.file_size, 0), COALESCE(d.preview_image, ''), COALESCE(d.tags, ''), COALESCE(d.description, ''),
+			COALESCE(d.platform, ''), COALESCE(d.uploader, '')
+		FROM downloads d
+		JOIN users u ON d.user_id = u.user_id`
+	countDownloadsQuery = `SELECT COUNT(*) FROM downloads d JOIN users u ON d.user_id = u.user_id`
+	totalsQuery         = `SELECT COALESCE(SUM(file_size), 0), COUNT(DISTINCT url) FROM processed_urls`
+	// userStatsQuery is completed with a WHERE clause on u and the
+	// ORDER BY and LIMIT clauses.
+	userStatsQuery = `
+		SELECT u.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			COUNT(d.id), COALESCE(SUM(d.file_size), 0), MAX(d.timestamp)
+		FROM users u
+		LEFT JOIN downloads d ON d.user_id = u.user_id`
+	userStatsGroupBy = `GROUP BY u.id, u.user_id, u.username, u.first_name, u.last_name`
+	// tagsQuery is completed with a WHERE clause.
+	tagsQuery = `SELECT DISTINCT p.url, p.tags FROM processed_urls p`
+)
+
+// User is a Telegram user. UserID is their Telegram ID.
+type User struct {
+	UserID    int64
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+// Download is a completed download to record. Size is that of the stored
+// object, ContentHash its key in media_objects.
+type Download struct {
+	User         User
+	URL          string
+	Size         int64
+	PreviewImage string
+	Tags         string
+	Description  string
+	Platform     string
+	Uploader     string
+	ContentHash  string
+}
+
+// StoryItem is a sent story item. URL is the link of the item itself.
+type StoryItem struct {
+	StoryID     string
+	TakenAt     int64
+	URL         string
+	Size        int64
+	ContentHash string
+}
+
+// Failure is a failed download, kept to report failure rates.
+type Failure struct {
+	User       User
+	URL        string
+	Platform   string
+	Mode       string
+	ErrorClass string
+	Error      string
+}
+
+// saveUser creates the user, or refreshes their profile, which changes when
+// they rename their Telegram account.
+func saveUser(tx *txStmts, user User) error {
+	err := tx.exec(upsertUserQuery, user.UserID, user.Username, user.FirstName, user.LastName)
+	if err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+	return nil
+}
+
+// RecordDownload writes the user, the download, the processed URL and the
+// user's byte count together or not at all.
+func (r *Repository) RecordDownload(d Download) error {
+	return r.inTx(func(tx *txStmts) error {
+		if err := saveUser(tx, d.User); err != nil {
+			return err
+		}
+		err := tx.exec(insertDownloadQuery, d.User.UserID, d.URL, d.Size, d.PreviewImage, d.Tags, d.Description, d.Platform, d.Uploader, d.ContentHash)
+		if err != nil {
+			return fmt.Errorf("failed to save download: %w", err)
+		}
+		err = tx.exec(insertProcessedURLQuery, d.URL, d.Size, d.PreviewImage, d.Tags, d.Description, d.Platform, d.Uploader, d.ContentHash)
+		if err != nil {
+			return fmt.Errorf("failed to save URL: %w", err)
+		}
+		// Both the download and the processed URL refer to the media.
+		err = tx.exec(linkMediaObjectQuery, 2, d.ContentHash, d.PreviewImage)
+		if err != nil {
+			return fmt.Errorf("failed to reference media: %w", err)
+		}
+		err = tx.exec(addUserBytesQuery, d.Size, d.User.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to update user total bytes downloaded: %w", err)
+		}
+		return nil
+	})
+}
+
+// RecordStories records the story items sent to chatID in one transaction,
+// so they are skipped next time, and counts them as downloads of user.
+func (r *Repository) RecordStories(user User, chatID int64, platform, owner string, items []StoryItem) error {
+	return r.inTx(func(tx *txStmts) error {
+		if err := saveUser(tx, user); err != nil {
+			return err
+		}
+		for _, item := range items {
+			err := tx.exec(insertStoryItemQuery, item.StoryID, owner, item.TakenAt, chatID, user.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to save story item: %w", err)
+			}
+			err = tx.exec(insertDownloadQuery, user.UserID, item.URL, item.Size, "", "", "", platform, owner, item.ContentHash)
+			if err != nil {
+				return fmt.Errorf("failed to save download: %w", err)
+			}
+			err = tx.exec(linkMediaObjectQuery, 1, item.ContentHash, "")
+			if err != nil {
+				return fmt.Errorf("failed to reference media: %w", err)
+			}
+			err = tx.exec(addUserBytesQuery, item.Size, user.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to update user total bytes downloaded: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// RecordFailure saves a failed download and its user.
+func (r *Repository) RecordFailure(f Failure) error {
+	return r.inTx(func(tx *txStmts) error {
+		if err := saveUser(tx, f.User); err != nil {
+			return err
+		}
+		return tx.exec(insertFailedDownloadQuery, f.User.UserID, f.URL, f.Platform, f.Mode, f.ErrorClass, f.Error)
+	})
+}
+
+// StorySeen reports whether the story item was already sent to chatID.
+func (r *Repository) StorySeen(storyID string, chatID int64) (bool, error) {
+	var seen int
+	err := r.scanRow(selectStoryItemQuery, []interface{}{storyID, chatID}, &seen)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type ProcessedURL struct {
+	ID           int64
+	URL          string
+	Timestamp    string
+	FileSize     int64
+	PreviewImage string
+	Tags         string
+	Description  string
+	Platform     string
+	Uploader     string
+	ContentHash  string
+	// SharedWith is the number of URLs with the same content.
+	SharedWith int
+}
+
+// ProcessedURLFilter narrows ListProcessedURLs. Empty fields match all. The
+// user filters match the URLs the user downloaded.
+type ProcessedURLFilter struct {
+	ListFilter
+	ContentHash string
+}
+
+// processedURLSorts are the sort keys of ListProcessedURLs.
+var processedURLSorts = map[string]string{
+	"timestamp": "p.timestamp",
+	"size":      "COALESCE(p.file_size, 0)",
+	"url":       "p.url",
+	"platform":  "COALESCE(p.platform, '')",
+	"uploader":  "COALESCE(p.uploader, '')",
+}
+
+func processedURLConditions(filter ProcessedURLFilter) *conditions {
+	var c conditions
+	c.addListFilter(filter.ListFilter, "p",
+		"p.url IN (SELECT url FROM downloads WHERE user_id = ?)",
+		"p.url IN (SELECT d.url FROM downloads d JOIN users u ON u.user_id = d.user_id WHERE LOWER(u.username) = ?)")
+	if filter.ContentHash != "" {
+		c.add("p.content_hash = ?", filter.ContentHash)
+	}
+	return &c
+}
+
+// ListProcessedURLs returns one page of the processed URLs matching filter
+// and how many match in total.
+func (r *Repository) ListProcessedURLs(filter ProcessedURLFilter, page Page) ([]ProcessedURL, int, error) {
+	page = page.normalize()
+	c := processedURLConditions(filter)
+
+	var total int
+	if err := r.scanBuiltRow(countProcessedURLsQuery+" "+c.where(), c.args, &total); err != nil {
+		return nil, 0, err
+	}
+	query := listProcessedURLsQuery + " " + c.where() + " " + page.orderBy(processedURLSorts, "timestamp", "p.id") + " LIMIT ? OFFSET ?"
+	urls, err := r.scanProcessedURLs(query, append(c.args, page.Size, page.offset())...)
+	return urls, total, err
+}
+
+// ProcessedURLsAfter returns the processed URLs matching filter in the
+// page of cursor, and the After of the next page, 0 after the last one.
+func (r *Repository) ProcessedURLsAfter(filter ProcessedURLFilter, cursor Cursor) ([]ProcessedURL, int64, error) {
+	cursor = cursor.normalize()
+	c := processedURLConditions(filter)
+	cursor.add(c, "p.id")
+
+	query := listProcessedURLsQuery + " " + c.where() + " " + cursor.orderBy("p.id") + " LIMIT ?"
+	urls, err := r.scanProcessedURLs(query, append(c.args, cursor.limit())...)
+	if err != nil || len(urls) <= cursor.Limit {
+		return urls, 0, err
+	}
+	urls = urls[:cursor.Limit]
+	return urls, urls[len(urls)-1].ID, nil
+}
+
+func (r *Repository) scanProcessedURLs(query string, args ...interface{}) ([]ProcessedURL, error) {
+	rows, err := r.queryBuilt(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []ProcessedURL
+	for rows.Next() {
+		var u ProcessedURL
+		err := rows.Scan(&u.ID, &u.URL, &u.Timestamp, &u.FileSize, &u.PreviewImage, &u.Tags, &u.Description, &u.Platform, &u.Uploader, &u.ContentHash, &u.SharedWith)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+type UserDownload struct {
+	ID           int64
+	UserID       int64
+	Username     string
+	FirstName    string
+	LastName     string
+	URL          string
+	Timestamp    string
+	FileSize     int64
+	PreviewImage string
+	Tags         string
+	Description  string
+	Platform     string
+	Uploader     string
+}
+
+// downloadSorts are the sort keys of ListDownloads.
+var downloadSorts = map[string]string{
+	"timestamp": "d.timestamp",
+	"size":      "COALESCE(d.file_size, 0)",
+	"url":       "d.url",
+	"platform":  "COALESCE(d.platform, '')",
+	"uploader":  "COALESCE(d.uploader, '')",
+	"user":      "d.user_id",
+	"username":  "COALESCE(u.username, '')",
+}
+
+func downloadConditions(filter ListFilter) *conditions {
+	var c conditions
+	c.addListFilter(filter, "d", "d.user_id = ?", "LOWER(u.username) = ?")
+	return &c
+}
+
+// ListDownloads returns one page of the downloads matching filter and how
+// many match in total.
+func (r *Repository) ListDownloads(filter ListFilter, page Page) ([]UserDownload, int, error) {
+	page = page.normalize()
+	c := downloadConditions(filter)
+
+	var total int
+	if err := r.scanBuiltRow(countDownloadsQuery+" "+c.where(), c.args, &total); err != nil {
+		return nil, 0, err
+	}
+	query := listDownloadsQuery + " " + c.where() + " " + page.orderBy(downloadSorts, "timestamp", "d.id") + " LIMIT ? OFFSET ?"
+	downloads, err := r.scanDownloads(query, append(c.args, page.Size, page.offset())...)
+	return downloads, total, err
+}
+
+// DownloadsAfter returns the downloads matching filter in the page of
+// cursor, and the After of the next page, 0 after the last one.
+func (r *Repository) DownloadsAfter(filter ListFilter, cursor Cursor) ([]UserDownload, int64, error) {
+	cursor = cursor.normalize()
+	c := downloadConditions(filter)
+	cursor.add(c, "d.id")
+
+	query := listDownloadsQuery + " " + c.where() + " " + cursor.orderBy("d.id") + " LIMIT ?"
+	downloads, err := r.scanDownloads(query, append(c.args, cursor.limit())...)
+	if err != nil || len(downloads) <= cursor.Limit {
+		return downloads, 0, err
+	}
+	downloads = downloads[:cursor.Limit]
+	return downloads, downloads[len(downloads)-1].ID, nil
+}
+
+// EachDownload calls fn with each download matching filter, oldest first.
+// Rows are read one at a time, so exports of the whole table don't have to
+// fit in memory. It stops at the first error of fn and returns it.
+func (r *Repository) EachDownload(filter ListFilter, fn func(UserDownload) error) error {
+	c := downloadConditions(filter)
+	return r.eachDownload(listDownloadsQuery+" "+c.where()+" ORDER BY d.id", c.args, fn)
+}
+
+func (r *Repository) scanDownloads(query string, args ...interface{}) ([]UserDownload, error) {
+	var downloads []UserDownload
+	err := r.eachDownload(query, args, func(d UserDownload) error {
+		downloads = append(downloads, d)
+		return nil
+	})
+	return downloads, err
+}
+
+func (r *Repository) eachDownload(query string, args []interface{}, fn func(UserDownload) error) error {
+	rows, err := r.queryBuilt(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d UserDownload
+		err := rows.Scan(&d.ID, &d.UserID, &d.Username, &d.FirstName, &d.LastName, &d.URL, &d.Timestamp, &d.FileSize, &d.PreviewImage, &d.Tags, &d.Description, &d.Platform, &d.Uploader)
+		if err != nil {
+			return err
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Totals returns the size of all processed URLs and how many there are.
+func (r *Repository) Totals() (size int64, urls int, err error) {
+	err = r.scanRow(totalsQuery, nil, &size, &urls)
+	return size, urls, err
+}
+
+type UserStats struct {
+	UserID       int64
+	Username     string
+	FirstName    string
+	LastName     string
+	Downloads    int
+	Bytes        int64
+	LastDownload string
+}
+
+// UsersAfter returns the users in the page of cursor, by Telegram ID, and
+// the After of the next page, 0 after the last one. A username other than
+// "" only matches that user.
+func (r *Repository) UsersAfter(username string, cursor Cursor) ([]UserStats, int64, error) {
+	cursor = cursor.normalize()
+	var c conditions
+	if username != "" {
+		c.add("LOWER(u.username) = ?", strings.ToLower(strings.TrimPrefix(username, "@")))
+	}
+	cursor.add(&c, "u.user_id")
+
+	query := userStatsQuery + " " + c.where() + " " + userStatsGroupBy + " " + cursor.orderBy("u.user_id") + " LIMIT ?"
+	users, err := r.scanUserStats(query, append(c.args, cursor.limit())...)
+	if err != nil || len(users) <= cursor.Limit {
+		return users, 0, err
+	}
+	users = users[:cursor.Limit]
+	return users, users[len(users)-1].UserID, nil
+}
+
+// User returns the user with the Telegram ID, or ErrNotFound.
+func (r *Repository) User(userID int64) (UserStats, error) {
+	users, err := r.scanUserStats(userStatsQuery+" WHERE u.user_id = ? "+userStatsGroupBy, userID)
+	if err == nil && len(users) == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return UserStats{}, err
+	}
+	return users[0], nil
+}
+
+// EachUser calls fn with each user, by Telegram ID, like EachDownload.
+// Without filters, all users are returned. With filters, only the users
+// with matching downloads are, and their stats count only those downloads.
+func (r *Repository) EachUser(filter ListFilter, fn func(UserStats) error) error {
+	var c conditions
+	c.addListFilter(filter, "d", "u.user_id = ?", "LOWER(u.username) = ?")
+	return r.eachUserStats(userStatsQuery+" "+c.where()+" "+userStatsGroupBy+" ORDER BY u.user_id", c.args, fn)
+}
+
+func (r *Repository) scanUserStats(query string, args ...interface{}) ([]UserStats, error) {
+	var stats []UserStats
+	err := r.eachUserStats(query, args, func(s UserStats) error {
+		stats = append(stats, s)
+		return nil
+	})
+	return stats, err
+}
+
+func (r *Repository) eachUserStats(query string, args []interface{}, fn func(UserStats) error) error {
+	rows, err := r.queryBuilt(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s UserStats
+		var lastDownload sql.NullString
+		err := rows.Scan(&s.UserID, &s.Username, &s.FirstName, &s.LastName, &s.Downloads, &s.Bytes, &lastDownload)
+		if err != nil {
+			return err
+		}
+		s.LastDownload = lastDownload.String
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// TopTags returns the limit tags found on the most processed URLs in the
+// range. Tags are stored as a comma separated list, so they are counted
+// here rather than in SQL.
+func (r *Repository) TopTags(filter StatsFilter, limit int) ([]TagCount, error) {
+	c := filter.conditions("p")
+	c.add("COALESCE(p.tags, '') != ''")
+	rows, err := r.queryBuilt(tagsQuery+" "+c.where(), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var url string
+		var tags sql.NullString
+		if err := rows.Scan(&url, &tags); err != nil {
+			return nil, err
+		}
+		for _, tag := range strings.Split(tags.String, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" {
+				counts[tag]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	top := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		top = append(top, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Tag < top[j].Tag
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
@@ -0,0 +1,17 @@
+package repository
+
+// PreparedStatements returns how many statements r keeps prepared.
+func (r *Repository) PreparedStatements() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.stmts)
+}
+
+// CopiedColumns returns the columns CopyToPostgres copies of each table.
+func CopiedColumns() map[string][]string {
+	columns := map[string][]string{}
+	for _, table := range copiedTables {
+		columns[table.Name] = table.Columns
+	}
+	return columns
+}
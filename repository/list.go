@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"strings"
+	"time"
+)
+
+// Page selects one page of a sorted list. Sort is one of the keys the list
+// accepts; an unknown or empty one falls back to the newest rows first.
+type Page struct {
+	Number int
+	Size   int
+	Sort   string
+	Desc   bool
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// normalize clamps the page number and size to usable values.
+func (p Page) normalize() Page {
+	if p.Number < 1 {
+		p.Number = 1
+	}
+	if p.Size < 1 {
+		p.Size = DefaultPageSize
+	}
+	if p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+	return p
+}
+
+func (p Page) offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// orderBy returns the ORDER BY clause of the page. sorts maps the accepted
+// keys to SQL expressions; they must not be NULL, which the dialects sort
+// differently. tieBreaker keeps the order stable between pages.
+func (p Page) orderBy(sorts map[string]string, fallback, tieBreaker string) string {
+	expr, ok := sorts[p.Sort]
+	if !ok {
+		return "ORDER BY " + sorts[fallback] + " DESC, " + tieBreaker + " DESC"
+	}
+	direction := " ASC"
+	if p.Desc {
+		direction = " DESC"
+	}
+	return "ORDER BY " + expr + direction + ", " + tieBreaker + direction
+}
+
+// Cursor selects a page of a list in id order, for clients that walk
+// through all of it: rows aren't skipped or repeated when new ones are
+// added in between, as they are with Page. After is the id of the last row
+// of the previous page, 0 on the first page.
+type Cursor struct {
+	After int64
+	Limit int
+	Asc   bool
+}
+
+func (c Cursor) normalize() Cursor {
+	c.Limit = Page{Size: c.Limit}.normalize().Size
+	return c
+}
+
+// add limits the rows to those after the cursor, by the id column.
+func (c Cursor) add(conds *conditions, id string) {
+	switch {
+	case c.After == 0:
+	case c.Asc:
+		conds.add(id+" > ?", c.After)
+	default:
+		conds.add(id+" < ?", c.After)
+	}
+}
+
+// orderBy returns the ORDER BY clause of the cursor.
+func (c Cursor) orderBy(id string) string {
+	if c.Asc {
+		return "ORDER BY " + id + " ASC"
+	}
+	return "ORDER BY " + id + " DESC"
+}
+
+// limit is the LIMIT of the query: one row more than the page, to tell
+// whether there is a next one.
+func (c Cursor) limit() int {
+	return c.Limit + 1
+}
+
+// ListFilter holds the filters the admin list pages share. Zero values
+// match all rows. To is inclusive: rows from any time that day match.
+type ListFilter struct {
+	Platform string
+	UserID   int64
+	Username string
+	Tag      string
+	From     time.Time
+	To       time.Time
+	MinSize  int64
+	MaxSize  int64
+}
+
+// conditions builds a WHERE clause from the filters in use.
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (c *conditions) add(clause string, args ...interface{}) {
+	c.clauses = append(c.clauses, clause)
+	c.args = append(c.args, args...)
+}
+
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// addListFilter adds the conditions of f on the table aliased t, which has
+// the platform, tags, timestamp and file_size columns of downloads and
+// processed_urls. userIDCondition and usernameCondition match the rows of a
+// user; they take the Telegram ID or the lowercased username.
+func (c *conditions) addListFilter(f ListFilter, t string, userIDCondition, usernameCondition string) {
+	if f.Platform != "" {
+		c.add("COALESCE("+t+".platform, 'instagram') = ?", f.Platform)
+	}
+	if f.UserID != 0 {
+		c.add(userIDCondition, f.UserID)
+	}
+	if f.Username != "" {
+		c.add(usernameCondition, strings.ToLower(strings.TrimPrefix(f.Username, "@")))
+	}
+	if f.Tag != "" {
+		// Tags are stored as "a, b, c"; match whole tags only.
+		c.add(`',' || REPLACE(LOWER(COALESCE(`+t+`.tags, '')), ', ', ',') || ',' LIKE ? ESCAPE '\'`,
+			"%,"+escapeLike(strings.ToLower(strings.TrimSpace(f.Tag)))+",%")
+	}
+	if !f.From.IsZero() {
+		c.add(t+".timestamp >= ?", nullTime(f.From))
+	}
+	if !f.To.IsZero() {
+		c.add(t+".timestamp < ?", nullTime(f.To.AddDate(0, 0, 1)))
+	}
+	if f.MinSize > 0 {
+		c.add("COALESCE("+t+".file_size, 0) >= ?", f.MinSize)
+	}
+	if f.MaxSize > 0 {
+		c.add("COALESCE("+t+".file_size, 0) <= ?", f.MaxSize)
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,26 @@
+package repository
+
+var (
+	selectMediaObjectQuery = `SELECT key FROM media_objects WHERE hash = ?`
+	upsertMediaObjectQuery = `INSERT INTO media_objects (hash, key, size) VALUES (?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET last_used_at = CURRENT_TIMESTAMP`
+	// linkMediaObjectQuery adds rows referring to the content with hash, or
+	// to the preview stored under key, to its ref_count.
+	linkMediaObjectQuery = `UPDATE media_objects SET ref_count = ref_count + ? WHERE hash = ? OR key = ?`
+)
+
+// MediaObjectKey returns the storage key of the content with hash, or
+// ErrNotFound when it isn't stored.
+func (r *Repository) MediaObjectKey(hash string) (string, error) {
+	var key string
+	err := r.scanRow(selectMediaObjectQuery, []interface{}{hash}, &key)
+	return key, err
+}
+
+// SaveMediaObject records the stored object the first time and marks it as
+// used again afterwards, which keeps it from expiring. Its ref_count goes up
+// once a download recorded with RecordDownload or RecordStories refers to
+// it; the janitor deletes objects nothing ever referred to.
+func (r *Repository) SaveMediaObject(hash, key string, size int64) error {
+	return r.exec(upsertMediaObjectQuery, hash, key, size)
+}
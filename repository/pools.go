@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	selectCookieAccountQuery = `SELECT healthy, COALESCE(last_error, ''), last_used_at, checked_at FROM cookie_accounts WHERE platform = ? AND name = ?`
+	listCookieAccountsQuery  = `SELECT name, healthy, COALESCE(last_error, ''), last_used_at, checked_at FROM cookie_accounts WHERE platform = ? ORDER BY name`
+	upsertCookieAccountQuery = `INSERT INTO cookie_accounts (platform, name, healthy, last_used_at, last_error, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (platform, name) DO UPDATE SET healthy = excluded.healthy, last_used_at = excluded.last_used_at,
+			last_error = excluded.last_error, checked_at = excluded.checked_at`
+	touchCookieAccountQuery = `INSERT INTO cookie_accounts (platform, name, last_used_at) VALUES (?, ?, ?)
+		ON CONFLICT (platform, name) DO UPDATE SET last_used_at = excluded.last_used_at`
+	selectProxyStatsQuery = `SELECT proxy, successes, failures, consecutive_failures, banned_until, COALESCE(last_error, ''), last_used_at
+		FROM proxy_stats WHERE proxy = ?`
+	listProxyStatsQuery = `SELECT proxy, successes, failures, consecutive_failures, banned_until, COALESCE(last_error, ''), last_used_at
+		FROM proxy_stats ORDER BY proxy`
+	// upsertProxyStatsQuery adds to the counters rather than overwriting
+	// them, as every service using the proxy reports to the same row. A
+	// success resets the consecutive failures; the last error is kept.
+	upsertProxyStatsQuery = `INSERT INTO proxy_stats (proxy, successes, failures, consecutive_failures, last_error, last_used_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (proxy) DO UPDATE SET successes = proxy_stats.successes + excluded.successes,
+			failures = proxy_stats.failures + excluded.failures,
+			consecutive_failures = CASE WHEN excluded.failures = 0 THEN 0
+				ELSE proxy_stats.consecutive_failures + excluded.failures END,
+			last_error = COALESCE(excluded.last_error, proxy_stats.last_error), last_used_at = excluded.last_used_at`
+
+	// banProxyQueries ban a proxy in each dialect without shortening a
+	// longer ban another service set. SQLite's MAX is NULL when an argument
+	// is, hence the COALESCE; PostgreSQL's GREATEST skips NULLs.
+	banProxyQueries = map[Dialect]string{
+		SQLite:   `UPDATE proxy_stats SET banned_until = MAX(COALESCE(banned_until, ''), ?), consecutive_failures = 0 WHERE proxy = ?`,
+		Postgres: `UPDATE proxy_stats SET banned_until = GREATEST(banned_until, ?), consecutive_failures = 0 WHERE proxy = ?`,
+	}
+)
+
+// CookieAccount is the saved state of one cookies file of a platform.
+type CookieAccount struct {
+	Platform  string
+	Name      string
+	Healthy   bool
+	LastUsed  time.Time
+	LastError string
+	CheckedAt time.Time
+}
+
+// CookieAccount returns the saved state of an account, or ErrNotFound.
+func (r *Repository) CookieAccount(platform, name string) (CookieAccount, error) {
+	account := CookieAccount{Platform: platform, Name: name}
+	var lastUsed, checkedAt sql.NullTime
+	err := r.scanRow(selectCookieAccountQuery, []interface{}{platform, name}, &account.Healthy, &account.LastError, &lastUsed, &checkedAt)
+	account.LastUsed, account.CheckedAt = lastUsed.Time, checkedAt.Time
+	return account, err
+}
+
+// CookieAccounts returns the saved state of the accounts of a platform.
+func (r *Repository) CookieAccounts(platform string) ([]CookieAccount, error) {
+	rows, err := r.query(listCookieAccountsQuery, platform)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []CookieAccount
+	for rows.Next() {
+		account := CookieAccount{Platform: platform}
+		var lastUsed, checkedAt sql.NullTime
+		if err := rows.Scan(&account.Name, &account.Healthy, &account.LastError, &lastUsed, &checkedAt); err != nil {
+			return nil, err
+		}
+		account.LastUsed, account.CheckedAt = lastUsed.Time, checkedAt.Time
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// SaveCookieAccount stores the health of an account.
+func (r *Repository) SaveCookieAccount(account CookieAccount) error {
+	return r.exec(upsertCookieAccountQuery, account.Platform, account.Name, account.Healthy,
+		nullTime(account.LastUsed), account.LastError, nullTime(account.CheckedAt))
+}
+
+// TouchCookieAccount records that an account was used without changing its
+// health, which another service may have changed meanwhile.
+func (r *Repository) TouchCookieAccount(platform, name string, lastUsed time.Time) error {
+	return r.exec(touchCookieAccountQuery, platform, name, nullTime(lastUsed))
+}
+
+// ProxyStats are the counters of one proxy. Proxy is its URL without the
+// password.
+type ProxyStats struct {
+	Proxy               string
+	Successes           int
+	Failures            int
+	ConsecutiveFailures int
+	BannedUntil         time.Time
+	LastError           string
+	LastUsedAt          time.Time
+}
+
+// Banned reports whether the proxy is banned now.
+func (p ProxyStats) Banned() bool {
+	return p.BannedUntil.After(time.Now())
+}
+
+// ProxyStats returns the counters of a proxy, or ErrNotFound.
+func (r *Repository) ProxyStats(proxy string) (ProxyStats, error) {
+	stmt, err := r.stmt(selectProxyStatsQuery)
+	if err != nil {
+		return ProxyStats{}, err
+	}
+	stats, err := scanProxyStats(stmt.QueryRow(proxy))
+	if err == sql.ErrNoRows {
+		return stats, ErrNotFound
+	}
+	return stats, err
+}
+
+func (r *Repository) ListProxyStats() ([]ProxyStats, error) {
+	rows, err := r.query(listProxyStatsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proxies []ProxyStats
+	for rows.Next() {
+		stats, err := scanProxyStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, stats)
+	}
+	return proxies, rows.Err()
+}
+
+// RecordProxyResult counts a request made through proxy, a failure when
+// lastError is set, marks the proxy as used now and returns its counters.
+func (r *Repository) RecordProxyResult(proxy, lastError string) (ProxyStats, error) {
+	successes, failures := 1, 0
+	var errorText interface{}
+	if lastError != "" {
+		successes, failures, errorText = 0, 1, lastError
+	}
+	if err := r.exec(upsertProxyStatsQuery, proxy, successes, failures, failures, errorText); err != nil {
+		return ProxyStats{}, err
+	}
+	return r.ProxyStats(proxy)
+}
+
+// BanProxy bans a recorded proxy until the given time, unless it is already
+// banned for longer, resets its consecutive failures and returns its
+// counters.
+func (r *Repository) BanProxy(proxy string, until time.Time) (ProxyStats, error) {
+	if err := r.exec(banProxyQueries[r.db.Dialect], nullTime(until), proxy); err != nil {
+		return ProxyStats{}, err
+	}
+	return r.ProxyStats(proxy)
+}
+
+func scanProxyStats(row interface{ Scan(...interface{}) error }) (ProxyStats, error) {
+	var stats ProxyStats
+	var bannedUntil, lastUsedAt sql.NullTime
+	err := row.Scan(&stats.Proxy, &stats.Successes, &stats.Failures, &stats.ConsecutiveFailures, &bannedUntil, &stats.LastError, &lastUsedAt)
+	stats.BannedUntil, stats.LastUsedAt = bannedUntil.Time, lastUsedAt.Time
+	return stats, err
+}
+
+// nullTime stores zero times as NULL and others in UTC, the way SQLite's
+// CURRENT_TIMESTAMP does.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.DateTime)
+}
@@ -0,0 +1,150 @@
+// Package repository is the data access layer of the shared database. The
+// downloader and the admin go through its typed methods instead of writing
+// SQL themselves. A Repository holds one long-lived connection pool, to
+// SQLite or PostgreSQL, and prepares each query once.
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned by lookups of a single row that doesn't exist.
+var ErrNotFound = errors.New("repository: not found")
+
+type Repository struct {
+	db *DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// Open opens the database selected by OpenDB. Statements are prepared on
+// first use, so the schema may be migrated after Open.
+func Open(sqlitePath string) (*Repository, error) {
+	db, err := OpenDB(sqlitePath)
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{db: db, stmts: map[string]*sql.Stmt{}}, nil
+}
+
+// DB returns the underlying pool, for migrations and the janitor.
+func (r *Repository) DB() *DB {
+	return r.db
+}
+
+func (r *Repository) Close() error {
+	r.mu.Lock()
+	for _, stmt := range r.stmts {
+		stmt.Close()
+	}
+	r.stmts = map[string]*sql.Stmt{}
+	r.mu.Unlock()
+	return r.db.Close()
+}
+
+// stmt returns the prepared statement of query, preparing it the first time.
+// Only the fixed queries of the package go through it: queries built from
+// filters and sorts have too many variants to keep prepared, and run with
+// queryBuilt and scanBuiltRow instead.
+func (r *Repository) stmt(query string) (*sql.Stmt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stmt, ok := r.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	r.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (r *Repository) exec(query string, args ...interface{}) error {
+	stmt, err := r.stmt(query)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(args...)
+	return err
+}
+
+// execCount runs query and returns how many rows it changed.
+func (r *Repository) execCount(query string, args ...interface{}) (int64, error) {
+	stmt, err := r.stmt(query)
+	if err != nil {
+		return 0, err
+	}
+	result, err := stmt.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *Repository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := r.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+// scanRow scans the single row of query into dest. A missing row is
+// reported as ErrNotFound.
+func (r *Repository) scanRow(query string, args []interface{}, dest ...interface{}) error {
+	stmt, err := r.stmt(query)
+	if err != nil {
+		return err
+	}
+	err = stmt.QueryRow(args...).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// queryBuilt runs a query built from filters without preparing it.
+func (r *Repository) queryBuilt(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.Query(query, args...)
+}
+
+// scanBuiltRow is scanRow for a query built from filters.
+func (r *Repository) scanBuiltRow(query string, args []interface{}, dest ...interface{}) error {
+	err := r.db.QueryRow(query, args...).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// inTx runs fn in a transaction, which is committed when fn succeeds.
+func (r *Repository) inTx(fn func(tx *txStmts) error) error {
+	tx, err := r.db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&txStmts{r: r, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// txStmts runs the prepared statements of a Repository inside a transaction.
+type txStmts struct {
+	r  *Repository
+	tx *sql.Tx
+}
+
+func (t *txStmts) exec(query string, args ...interface{}) error {
+	stmt, err := t.r.stmt(query)
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Stmt(stmt).Exec(args...)
+	return err
+}
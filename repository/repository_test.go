@@ -0,0 +1,640 @@
+package repository_test
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/repository"
+)
+
+// openRepository opens the database at sqlitePath, or DATABASE_URL when dsn
+// is set, and migrates it.
+func openRepository(t *testing.T, sqlitePath, dsn string) *repository.Repository {
+	t.Helper()
+	t.Setenv("DATABASE_URL", dsn)
+	repo, err := repository.Open(sqlitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := migrations.Up(repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// postgresSchema creates a schema in the PostgreSQL database at
+// TEST_DATABASE_URL, skipping the test when it isn't set, and returns the URL
+// of a connection using it. The schema is dropped afterwards.
+func postgresSchema(t *testing.T) string {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := repository.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("repository_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Logf("Failed to drop schema %s: %v", schema, err)
+		}
+		db.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// eachDatabase runs test against a fresh SQLite database and, when
+// TEST_DATABASE_URL is set, a fresh PostgreSQL schema.
+func eachDatabase(t *testing.T, test func(t *testing.T, repo *repository.Repository)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, openRepository(t, filepath.Join(t.TempDir(), "videos.db"), ""))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		test(t, openRepository(t, "", postgresSchema(t)))
+	})
+}
+
+var (
+	alice = repository.User{UserID: 1001, Username: "alice", FirstName: "Alice"}
+	bob   = repository.User{UserID: 1002, Username: "bob", FirstName: "Bob"}
+)
+
+// seed records downloads of alice and bob; the two reels share content.
+func seed(t *testing.T, repo *repository.Repository) {
+	t.Helper()
+	for _, d := range []repository.Download{
+		{User: alice, URL: "https://www.instagram.com/reel/a/", Size: 100, Tags: "cats, dogs", Platform: "instagram", Uploader: "zoo", ContentHash: "h1"},
+		{User: alice, URL: "https://www.tiktok.com/@zoo/video/1", Size: 300, Tags: "cats", Platform: "tiktok", Uploader: "zoo", ContentHash: "h2"},
+		{User: bob, URL: "https://www.instagram.com/reel/b/", Size: 200, Tags: "dogs", Platform: "instagram", Uploader: "farm", ContentHash: "h1"},
+	} {
+		if err := repo.RecordDownload(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := repo.RecordFailure(repository.Failure{User: bob, URL: "https://www.instagram.com/p/private/", Platform: "instagram", Mode: "video", ErrorClass: "private", Error: "private: video is private"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func urls(downloads []repository.UserDownload) string {
+	var list []string
+	for _, d := range downloads {
+		list = append(list, d.URL)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestRecordDownload(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		// Saving a user again refreshes their profile instead of adding a copy.
+		renamed := alice
+		renamed.Username = "alice2"
+		err := repo.RecordDownload(repository.Download{User: renamed, URL: "https://youtu.be/x", Size: 50, Platform: "youtube"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := repo.User(alice.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "alice2" || user.Downloads != 3 || user.Bytes != 450 {
+			t.Errorf("got user %+v", user)
+		}
+		if _, err := repo.User(9999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("unknown user: got %v, want ErrNotFound", err)
+		}
+
+		size, count, err := repo.Totals()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 650 || count != 4 {
+			t.Errorf("got totals %d bytes in %d URLs", size, count)
+		}
+	})
+}
+
+func TestListDownloads(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		for _, test := range []struct {
+			filter repository.ListFilter
+			want   string
+		}{
+			{repository.ListFilter{}, "https://www.instagram.com/reel/b/ https://www.tiktok.com/@zoo/video/1 https://www.instagram.com/reel/a/"},
+			{repository.ListFilter{Platform: "instagram"}, "https://www.instagram.com/reel/b/ https://www.instagram.com/reel/a/"},
+			{repository.ListFilter{Username: "@Alice"}, "https://www.tiktok.com/@zoo/video/1 https://www.instagram.com/reel/a/"},
+			{repository.ListFilter{UserID: bob.UserID}, "https://www.instagram.com/reel/b/"},
+			{repository.ListFilter{Tag: "cats", MinSize: 200}, "https://www.tiktok.com/@zoo/video/1"},
+			{repository.ListFilter{MaxSize: 50}, ""},
+		} {
+			downloads, total, err := repo.ListDownloads(test.filter, repository.Page{})
+			if err != nil {
+				t.Fatalf("%+v: %v", test.filter, err)
+			}
+			if got := urls(downloads); got != test.want || total != len(downloads) {
+				t.Errorf("%+v: got %d of %d: %s, want %s", test.filter, len(downloads), total, got, test.want)
+			}
+		}
+
+		downloads, total, err := repo.ListDownloads(repository.ListFilter{}, repository.Page{Number: 2, Size: 2, Sort: "size"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || urls(downloads) != "https://www.tiktok.com/@zoo/video/1" {
+			t.Errorf("second page by size: got %d of %d: %s", len(downloads), total, urls(downloads))
+		}
+	})
+}
+
+func TestCursor(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		var seen []string
+		cursor := repository.Cursor{Limit: 2, Asc: true}
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatal("cursor doesn't end")
+			}
+			page, next, err := repo.DownloadsAfter(repository.ListFilter{}, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, urls(page))
+			if next == 0 {
+				break
+			}
+			cursor.After = next
+		}
+		want := "https://www.instagram.com/reel/a/ https://www.tiktok.com/@zoo/video/1|https://www.instagram.com/reel/b/"
+		if got := strings.Join(seen, "|"); got != want {
+			t.Errorf("got pages %s, want %s", got, want)
+		}
+	})
+}
+
+func TestProcessedURLs(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		list, total, err := repo.ListProcessedURLs(repository.ProcessedURLFilter{ContentHash: "h1"}, repository.Page{Sort: "url"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || len(list) != 2 || list[0].URL != "https://www.instagram.com/reel/a/" {
+			t.Fatalf("got %d of %d: %+v", len(list), total, list)
+		}
+		if list[0].SharedWith != 2 {
+			t.Errorf("got SharedWith %d, want 2", list[0].SharedWith)
+		}
+
+		list, _, err = repo.ListProcessedURLs(repository.ProcessedURLFilter{ListFilter: repository.ListFilter{UserID: bob.UserID}}, repository.Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].URL != "https://www.instagram.com/reel/b/" {
+			t.Errorf("URLs of bob: got %+v", list)
+		}
+	})
+}
+
+// TestSearch checks the matches of a search, or, on SQLite built without
+// FTS5, that search reports it is unavailable.
+func TestSearch(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		available, err := repo.SearchAvailable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, total, err := repo.Search("Cats zoo", repository.Page{})
+		if !available {
+			if !errors.Is(err, repository.ErrSearchUnavailable) {
+				t.Errorf("got error %v, want ErrSearchUnavailable", err)
+			}
+			t.Skip("SQLite is built without FTS5, run the tests with -tags sqlite_fts5 to search")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, result := range results {
+			urls = append(urls, result.URL)
+		}
+		sort.Strings(urls)
+		if total != 2 || strings.Join(urls, " ") != "https://www.instagram.com/reel/a/ https://www.tiktok.com/@zoo/video/1" {
+			t.Errorf("got %d results: %v", total, urls)
+		}
+		if !strings.Contains(results[0].TagsSnippet, "<mark>cats</mark>") {
+			t.Errorf("got tags snippet %q", results[0].TagsSnippet)
+		}
+	})
+}
+
+func TestStatistics(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		summary, err := repo.DownloadSummary(repository.StatsFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Downloads != 3 || summary.Bytes != 600 || summary.ActiveUsers != 2 || summary.Failures != 1 {
+			t.Errorf("got summary %+v", summary)
+		}
+
+		summary, err = repo.DownloadSummary(repository.StatsFilter{UserID: bob.UserID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Downloads != 1 || summary.Bytes != 200 || summary.Failures != 1 {
+			t.Errorf("got summary of bob %+v", summary)
+		}
+
+		tags, err := repo.TopTags(repository.StatsFilter{}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 2 || tags[0].Count != 2 || tags[1].Count != 2 {
+			t.Errorf("got tags %+v", tags)
+		}
+
+		uploaders, err := repo.TopUploaders(repository.StatsFilter{Platform: "instagram"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uploaders) != 1 || uploaders[0].Uploader != "farm" || uploaders[0].Bytes != 200 {
+			t.Errorf("got uploaders %+v", uploaders)
+		}
+
+		failures, err := repo.FailuresByClass(repository.StatsFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failures) != 1 || failures[0].ErrorClass != "private" || failures[0].Count != 1 {
+			t.Errorf("got failures %+v", failures)
+		}
+
+		users, err := repo.TopUsers(repository.StatsFilter{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].UserID != alice.UserID {
+			t.Errorf("got top users %+v", users)
+		}
+	})
+}
+
+func TestUserAccess(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+
+		profile, err := repo.UserProfile(bob.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.Tier != "free" || profile.BannedAt != "" || profile.Failures != 1 {
+			t.Errorf("got profile %+v", profile)
+		}
+
+		if err := repo.SetUserTier(bob.UserID, "premium"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.BanUser(bob.UserID, "spam"); err != nil {
+			t.Fatal(err)
+		}
+		profile, err = repo.UserProfile(bob.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.Tier != "premium" || profile.BannedAt == "" || profile.BanReason != "spam" {
+			t.Errorf("got profile %+v after the ban", profile)
+		}
+
+		if err := repo.UnbanUser(bob.UserID); err != nil {
+			t.Fatal(err)
+		}
+		profile, err = repo.UserProfile(bob.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.BannedAt != "" || profile.BanReason != "" {
+			t.Errorf("got profile %+v after lifting the ban", profile)
+		}
+
+		for name, err := range map[string]error{
+			"tier":  repo.SetUserTier(9999, "premium"),
+			"ban":   repo.BanUser(9999, ""),
+			"unban": repo.UnbanUser(9999),
+		} {
+			if !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("%s of an unknown user: got %v, want ErrNotFound", name, err)
+			}
+		}
+
+		downloads, bytes, err := repo.UserUsage(alice.UserID, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if downloads != 2 || bytes != 400 {
+			t.Errorf("got usage of %d downloads and %d bytes", downloads, bytes)
+		}
+		downloads, _, err = repo.UserUsage(alice.UserID, time.Now().Add(time.Hour))
+		if err != nil || downloads != 0 {
+			t.Errorf("got %d downloads in the future: %v", downloads, err)
+		}
+	})
+}
+
+func TestMediaObjects(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+
+		if _, err := repo.MediaObjectKey("h1"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("missing object: got %v, want ErrNotFound", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := repo.SaveMediaObject("h1", "objects/h1.mp4", 100); err != nil {
+				t.Fatal(err)
+			}
+		}
+		key, err := repo.MediaObjectKey("h1")
+		if err != nil || key != "objects/h1.mp4" {
+			t.Errorf("got key %q: %v", key, err)
+		}
+		if err := repo.SaveMediaObject("p1", "objects/p1.jpg", 10); err != nil {
+			t.Fatal(err)
+		}
+
+		// Storing an object doesn't reference it, recording a download
+		// refers to its media and preview from two rows.
+		refs := func() string {
+			t.Helper()
+			var h1, p1 int
+			err := repo.DB().QueryRow(`SELECT
+				(SELECT ref_count FROM media_objects WHERE hash = 'h1'),
+				(SELECT ref_count FROM media_objects WHERE hash = 'p1')`).Scan(&h1, &p1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fmt.Sprintf("%d %d", h1, p1)
+		}
+		if got := refs(); got != "0 0" {
+			t.Errorf("stored objects: got ref counts %s, want 0 0", got)
+		}
+		err = repo.RecordDownload(repository.Download{User: alice, URL: "https://www.instagram.com/reel/a/", PreviewImage: "objects/p1.jpg", ContentHash: "h1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := refs(); got != "2 2" {
+			t.Errorf("after a download: got ref counts %s, want 2 2", got)
+		}
+	})
+}
+
+// TestCookieAccounts checks that recording the use of an account keeps the
+// health another service saved.
+func TestCookieAccounts(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+
+		if err := repo.TouchCookieAccount("instagram", "a", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		err := repo.SaveCookieAccount(repository.CookieAccount{Platform: "instagram", Name: "b", Healthy: false, LastError: "login required"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.TouchCookieAccount("instagram", "b", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		accounts, err := repo.CookieAccounts("instagram")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 2 || !accounts[0].Healthy || accounts[1].Healthy || accounts[1].LastError != "login required" {
+			t.Errorf("got accounts %+v", accounts)
+		}
+		if accounts[1].LastUsed.IsZero() {
+			t.Error("using account b didn't record its last use")
+		}
+	})
+}
+
+// TestProxyStats checks that reports of several services add up and that a
+// shorter ban doesn't cut a longer one.
+func TestProxyStats(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+
+		const proxy = "http://proxy:8080"
+		for _, lastError := range []string{"", "timeout", "", "refused", "refused"} {
+			if _, err := repo.RecordProxyResult(proxy, lastError); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats, err := repo.ProxyStats(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Successes != 2 || stats.Failures != 3 || stats.ConsecutiveFailures != 2 || stats.LastError != "refused" {
+			t.Errorf("got stats %+v", stats)
+		}
+
+		later := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+		if _, err := repo.BanProxy(proxy, later); err != nil {
+			t.Fatal(err)
+		}
+		stats, err = repo.BanProxy(proxy, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stats.BannedUntil.Equal(later) || stats.ConsecutiveFailures != 0 {
+			t.Errorf("got banned until %v with %d consecutive failures, want %v and 0", stats.BannedUntil, stats.ConsecutiveFailures, later)
+		}
+	})
+}
+
+func TestStories(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+
+		items := []repository.StoryItem{{StoryID: "s1", URL: "https://www.instagram.com/stories/zoo/1/", Size: 10}}
+		if err := repo.RecordStories(alice, 42, "instagram", "zoo", items); err != nil {
+			t.Fatal(err)
+		}
+		// Recording a story again for the same chat is not an error.
+		if err := repo.RecordStories(alice, 42, "instagram", "zoo", items); err != nil {
+			t.Fatal(err)
+		}
+		for chatID, want := range map[int64]bool{42: true, 43: false} {
+			seen, err := repo.StorySeen("s1", chatID)
+			if err != nil || seen != want {
+				t.Errorf("chat %d: got seen %v, %v", chatID, seen, err)
+			}
+		}
+	})
+}
+
+// TestBuiltQueriesAreNotPrepared checks that listing with different filters
+// and sorts doesn't leave a prepared statement behind for each variant.
+func TestBuiltQueriesAreNotPrepared(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, repo *repository.Repository) {
+		seed(t, repo)
+		before := repo.PreparedStatements()
+
+		for i, sort := range []string{"", "size", "url", "platform"} {
+			filter := repository.ListFilter{MinSize: int64(i)}
+			if i%2 == 0 {
+				filter.Platform = "instagram"
+			}
+			page := repository.Page{Sort: sort, Desc: i%2 == 0}
+			if _, _, err := repo.ListDownloads(filter, page); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := repo.ListProcessedURLs(repository.ProcessedURLFilter{ListFilter: filter}, page); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.DownloadSummary(repository.StatsFilter{Platform: filter.Platform}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.TopTags(repository.StatsFilter{UserID: int64(i)}, 5); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.TopUsers(repository.StatsFilter{Platform: filter.Platform}, i+1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.DownloadSeries(repository.StatsFilter{UserID: int64(i)}, "day"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if after := repo.PreparedStatements(); after != before {
+			t.Errorf("listing prepared %d statements", after-before)
+		}
+
+		// Fixed queries are prepared once.
+		repo.MediaObjectKey("h1")
+		repo.MediaObjectKey("h2")
+		if after := repo.PreparedStatements(); after != before+1 {
+			t.Errorf("a fixed query prepared %d statements", after-before)
+		}
+	})
+}
+
+// TestCopiedTablesCoverSchema checks that CopyToPostgres copies every column
+// of every table the migrations create, so a new table isn't left behind.
+func TestCopiedTablesCoverSchema(t *testing.T) {
+	repo := openRepository(t, filepath.Join(t.TempDir(), "videos.db"), "")
+	rows, err := repo.DB().Query(`SELECT m.name, p.name FROM sqlite_master m, pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.name NOT LIKE 'processed_urls_fts%'
+		AND m.name <> 'schema_migrations' ORDER BY m.name, p.cid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			t.Fatal(err)
+		}
+		schema[table] = append(schema[table], column)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := repository.CopiedColumns()
+	for table, columns := range schema {
+		if got := strings.Join(copied[table], ","); got != strings.Join(columns, ",") {
+			t.Errorf("%s: copying columns %q, the table has %q", table, got, strings.Join(columns, ","))
+		}
+	}
+	for table := range copied {
+		if schema[table] == nil {
+			t.Errorf("copying %s, which the schema doesn't have", table)
+		}
+	}
+}
+
+// TestCopyToPostgres copies a SQLite database with rows in every table to
+// PostgreSQL and compares the row counts.
+func TestCopyToPostgres(t *testing.T) {
+	dsn := postgresSchema(t)
+	src := openRepository(t, filepath.Join(t.TempDir(), "videos.db"), "")
+	seed(t, src)
+	items := []repository.StoryItem{{StoryID: "s1", URL: "https://www.instagram.com/stories/zoo/1/", Size: 10}}
+	if err := src.RecordStories(alice, 42, "instagram", "zoo", items); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveMediaObject("h1", "objects/h1.mp4", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveCookieAccount(repository.CookieAccount{Platform: "instagram", Name: "a", Healthy: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.RecordProxyResult("http://proxy:8080", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`INSERT INTO subscriptions (chat_id, user_id, username) VALUES (42, 1001, 'zoo')`,
+		`INSERT INTO followed_profiles (username, seeded) VALUES ('zoo', 1)`,
+		`INSERT INTO seen_posts (username, post_id) VALUES ('zoo', 'p1')`,
+		`INSERT INTO user_settings (user_id, language) VALUES (1001, 'ru')`,
+		`INSERT INTO broadcasts (kind, text, segment) VALUES ('text', 'hello', 'all')`,
+		`INSERT INTO broadcast_deliveries (broadcast_id, user_id) VALUES (1, 1001)`,
+		`INSERT INTO blocked_users (user_id) VALUES (1002)`,
+		`INSERT INTO admin_accounts (username, role) VALUES ('root', 'admin')`,
+		`INSERT INTO admin_sessions (id, account_id, csrf_token, expires_at) VALUES ('s', 1, 'c', '2030-01-01 00:00:00')`,
+		`INSERT INTO api_keys (name, key_hash) VALUES ('ci', 'k')`,
+		`INSERT INTO janitor_runs (started_at, finished_at, trigger, dry_run, deleted_count, deleted_bytes, error_count, details)
+			VALUES ('2026-01-01 00:00:00', '2026-01-01 00:00:01', 'test', 1, 0, 0, 0, '[]')`,
+	} {
+		if _, err := src.DB().Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	dst := openRepository(t, "", dsn)
+
+	if err := repository.CopyToPostgres(src.DB(), dst.DB()); err != nil {
+		t.Fatal(err)
+	}
+	for table := range repository.CopiedColumns() {
+		var want, got int
+		if err := src.DB().QueryRow("SELECT COUNT(*) FROM " + table).Scan(&want); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.DB().QueryRow("SELECT COUNT(*) FROM " + table).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if want == 0 || got != want {
+			t.Errorf("%s: copied %d of %d rows", table, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Markers around the matches in snippets. They are replaced with <mark>
+// tags once the rest of the snippet is escaped.
+const (
+	matchStart = "\x01"
+	matchEnd   = "\x02"
+)
+
+// ErrSearchUnavailable is returned by Search on SQLite databases whose search
+// migration is pending because SQLite was built without FTS5.
+var ErrSearchUnavailable = errors.New("repository: search needs SQLite built with FTS5")
+
+var searchIndexQuery = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'processed_urls_fts'`
+
+// searchQueries hold the search of each dialect. Both take the markers, the
+// query built by searchTerms and, for the list, LIMIT and OFFSET. Each URL
+// appears once, with its latest metadata.
+var searchQueries = map[Dialect]struct{ list, count string }{
+	SQLite: {
+		list: `
+			SELECT p.url, p.timestamp, COALESCE(p.file_size, 0), COALESCE(p.preview_image, ''), COALESCE(p.platform, ''),
+				COALESCE(p.content_hash, ''),
+				COALESCE(snippet(processed_urls_fts, 0, ?, ?, '…', 24), ''),
+				COALESCE(highlight(processed_urls_fts, 1, ?, ?), ''),
+				COALESCE(highlight(processed_urls_fts, 2, ?, ?), '')
+			FROM processed_urls_fts
+			JOIN processed_urls p ON p.id = processed_urls_fts.rowid
+			WHERE processed_urls_fts MATCH ?
+			AND p.id IN (SELECT MAX(id) FROM processed_urls GROUP BY url)
+			ORDER BY bm25(processed_urls_fts, 1.0, 2.0, 2.0), p.id DESC
+			LIMIT ? OFFSET ?`,
+		count: `
+			SELECT COUNT(*)
+			FROM processed_urls_fts
+			JOIN processed_urls p ON p.id = processed_urls_fts.rowid
+			WHERE processed_urls_fts MATCH ?
+			AND p.id IN (SELECT MAX(id) FROM processed_urls GROUP BY url)`,
+	},
+	Postgres: {
+		list: `
+			SELECT p.url, p.timestamp, COALESCE(p.file_size, 0), COALESCE(p.preview_image, ''), COALESCE(p.platform, ''),
+				COALESCE(p.content_hash, ''),
+				ts_headline('simple', COALESCE(p.description, ''), q, 'MaxFragments=2, MaxWords=24, MinWords=8, StartSel=' || ? || ', StopSel=' || ?),
+				ts_headline('simple', COALESCE(p.tags, ''), q, 'HighlightAll=true, StartSel=' || ? || ', StopSel=' || ?),
+				ts_headline('simple', COALESCE(p.uploader, ''), q, 'HighlightAll=true, StartSel=' || ? || ', StopSel=' || ?)
+			FROM processed_urls p, to_tsquery('simple', ?) q
+			WHERE p.search @@ q
+			AND p.id IN (SELECT MAX(id) FROM processed_urls GROUP BY url)
+			ORDER BY ts_rank(p.search, q) DESC, p.id DESC
+			LIMIT ? OFFSET ?`,
+		count: `
+			SELECT COUNT(*)
+			FROM processed_urls p, to_tsquery('simple', ?) q
+			WHERE p.search @@ q
+			AND p.id IN (SELECT MAX(id) FROM processed_urls GROUP BY url)`,
+	},
+}
+
+// SearchResult is a processed URL matching a search. The snippets are HTML,
+// with the matches in <mark> tags.
+type SearchResult struct {
+	URL                string
+	Timestamp          string
+	FileSize           int64
+	PreviewImage       string
+	Platform           string
+	ContentHash        string
+	DescriptionSnippet string
+	TagsSnippet        string
+	UploaderSnippet    string
+}
+
+// searchTerms splits a search into words, ignoring punctuation, so user
+// input can't break the query syntax of either dialect. Every word must
+// match, as a prefix.
+func searchTerms(dialect Dialect, search string) string {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if dialect == Postgres {
+			words[i] = word + ":*"
+		} else {
+			words[i] = `"` + word + `"*`
+		}
+	}
+	if dialect == Postgres {
+		return strings.Join(words, " & ")
+	}
+	return strings.Join(words, " ")
+}
+
+// highlightHTML escapes a snippet and turns the match markers into tags.
+func highlightHTML(snippet string) string {
+	return strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// SearchAvailable reports whether the database has the search index. It
+// always has on PostgreSQL.
+func (r *Repository) SearchAvailable() (bool, error) {
+	if r.db.Dialect != SQLite {
+		return true, nil
+	}
+	var tables int
+	err := r.scanRow(searchIndexQuery, nil, &tables)
+	return tables > 0, err
+}
+
+// Search returns one page of the processed URLs whose description, tags or
+// uploader match search, best matches first, and how many match in total.
+// The sort of page is ignored. Without the search index it returns
+// ErrSearchUnavailable.
+func (r *Repository) Search(search string, page Page) ([]SearchResult, int, error) {
+	available, err := r.SearchAvailable()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !available {
+		return nil, 0, ErrSearchUnavailable
+	}
+	page = page.normalize()
+	terms := searchTerms(r.db.Dialect, search)
+	if terms == "" {
+		return nil, 0, nil
+	}
+	queries := searchQueries[r.db.Dialect]
+
+	var total int
+	if err := r.scanRow(queries.count, []interface{}{terms}, &total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.query(queries.list, matchStart, matchEnd, matchStart, matchEnd, matchStart, matchEnd, terms, page.Size, page.offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var s SearchResult
+		err := rows.Scan(&s.URL, &s.Timestamp, &s.FileSize, &s.PreviewImage, &s.Platform, &s.ContentHash,
+			&s.DescriptionSnippet, &s.TagsSnippet, &s.UploaderSnippet)
+		if err != nil {
+			return nil, 0, err
+		}
+		s.DescriptionSnippet = highlightHTML(s.DescriptionSnippet)
+		s.TagsSnippet = highlightHTML(s.TagsSnippet)
+		s.UploaderSnippet = highlightHTML(s.UploaderSnippet)
+		results = append(results, s)
+	}
+	return results, total, rows.Err()
+}
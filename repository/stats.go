@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// The statistics queries are completed with a WHERE clause and, for
+	// the lists, GROUP BY, ORDER BY and LIMIT clauses.
+	downloadSummaryQuery = `
+		SELECT COUNT(*), COALESCE(SUM(d.file_size), 0), COALESCE(AVG(d.file_size), 0),
+			COUNT(DISTINCT d.user_id), COUNT(DISTINCT d.url)
+		FROM downloads d`
+	failureCountQuery = `SELECT COUNT(*) FROM failed_downloads f`
+	failureClassQuery = `SELECT COALESCE(f.error_class, 'unknown'), COUNT(*) FROM failed_downloads f`
+	topUploadersQuery = `SELECT d.uploader, COUNT(*), COALESCE(SUM(d.file_size), 0) FROM downloads d`
+	// downloadSeriesQuery is formatted with the period expression.
+	downloadSeriesQuery = `SELECT %[1]s, COUNT(*), COALESCE(SUM(d.file_size), 0), COUNT(DISTINCT d.user_id) FROM downloads d %[2]s GROUP BY %[1]s ORDER BY %[1]s`
+
+	// periodExpressions turn d.timestamp into the first day of its period,
+	// as YYYY-MM-DD. Weeks start on Monday.
+	periodExpressions = map[Dialect]map[string]string{
+		SQLite: {
+			Day:  "DATE(d.timestamp)",
+			Week: "DATE(d.timestamp, 'weekday 0', '-6 days')",
+		},
+		Postgres: {
+			Day:  "TO_CHAR(DATE_TRUNC('day', d.timestamp), 'YYYY-MM-DD')",
+			Week: "TO_CHAR(DATE_TRUNC('week', d.timestamp), 'YYYY-MM-DD')",
+		},
+	}
+)
+
+// Intervals of DownloadSeries.
+const (
+	Day  = "day"
+	Week = "week"
+)
+
+const periodLayout = "2006-01-02"
+
+// StatsFilter selects the downloads and failures the statistics cover.
+// Zero values match all rows; To is inclusive, like in ListFilter. UserID
+// narrows them to one user by Telegram ID.
+type StatsFilter struct {
+	Platform string
+	From     time.Time
+	To       time.Time
+	UserID   int64
+}
+
+// conditions returns the conditions of f on the table aliased t, which has
+// platform and timestamp columns. processed_urls, aliased p, has no user_id
+// column; its URLs of a user are those they downloaded.
+func (f StatsFilter) conditions(t string) *conditions {
+	userIDCondition := t + ".user_id = ?"
+	if t == "p" {
+		userIDCondition = "p.url IN (SELECT url FROM downloads WHERE user_id = ?)"
+	}
+	var c conditions
+	c.addListFilter(ListFilter{Platform: f.Platform, From: f.From, To: f.To, UserID: f.UserID}, t, userIDCondition, "")
+	return &c
+}
+
+// Summary is the totals of the downloads and failures in a range.
+type Summary struct {
+	Downloads   int
+	Bytes       int64
+	AverageSize float64
+	ActiveUsers int
+	URLs        int
+	Failures    int
+}
+
+// FailureRate is the share of download attempts that failed.
+func (s Summary) FailureRate() float64 {
+	if s.Downloads+s.Failures == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Downloads+s.Failures)
+}
+
+func (r *Repository) DownloadSummary(filter StatsFilter) (Summary, error) {
+	var s Summary
+	c := filter.conditions("d")
+	err := r.scanBuiltRow(downloadSummaryQuery+" "+c.where(), c.args, &s.Downloads, &s.Bytes, &s.AverageSize, &s.ActiveUsers, &s.URLs)
+	if err != nil {
+		return s, err
+	}
+	c = filter.conditions("f")
+	err = r.scanBuiltRow(failureCountQuery+" "+c.where(), c.args, &s.Failures)
+	return s, err
+}
+
+// SeriesPoint is the downloads of one day or week. Period is its first day.
+type SeriesPoint struct {
+	Period      string
+	Downloads   int
+	Bytes       int64
+	ActiveUsers int
+}
+
+// DownloadSeries returns the downloads per day or week. When the filter has
+// both From and To, periods without downloads are included, with zeros.
+func (r *Repository) DownloadSeries(filter StatsFilter, interval string) ([]SeriesPoint, error) {
+	period, ok := periodExpressions[r.db.Dialect][interval]
+	if !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+	c := filter.conditions("d")
+	rows, err := r.queryBuilt(fmt.Sprintf(downloadSeriesQuery, period, c.where()), c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []SeriesPoint
+	for rows.Next() {
+		var p SeriesPoint
+		if err := rows.Scan(&p.Period, &p.Downloads, &p.Bytes, &p.ActiveUsers); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return points, nil
+	}
+	return fillSeries(points, filter.From, filter.To, interval), nil
+}
+
+// fillSeries adds the periods from from to to that points lacks.
+func fillSeries(points []SeriesPoint, from, to time.Time, interval string) []SeriesPoint {
+	step := 1
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	if interval == Week {
+		step = 7
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+
+	found := map[string]SeriesPoint{}
+	for _, p := range points {
+		found[p.Period] = p
+	}
+	var filled []SeriesPoint
+	for day := start; !day.After(to); day = day.AddDate(0, 0, step) {
+		period := day.Format(periodLayout)
+		p, ok := found[period]
+		if !ok {
+			p = SeriesPoint{Period: period}
+		}
+		filled = append(filled, p)
+	}
+	return filled
+}
+
+// TopUsers returns the limit users with the most downloads in the range.
+// Without a filter, users without downloads may be among them.
+func (r *Repository) TopUsers(filter StatsFilter, limit int) ([]UserStats, error) {
+	c := filter.conditions("d")
+	query := userStatsQuery + " " + c.where() + " " + userStatsGroupBy + " ORDER BY COUNT(d.id) DESC, u.user_id LIMIT ?"
+	return r.scanUserStats(query, append(c.args, limit)...)
+}
+
+type UploaderCount struct {
+	Uploader  string
+	Downloads int
+	Bytes     int64
+}
+
+// TopUploaders returns the limit uploaders whose posts were downloaded the
+// most in the range.
+func (r *Repository) TopUploaders(filter StatsFilter, limit int) ([]UploaderCount, error) {
+	c := filter.conditions("d")
+	c.add("COALESCE(d.uploader, '') != ''")
+	query := topUploadersQuery + " " + c.where() + " GROUP BY d.uploader ORDER BY COUNT(*) DESC, d.uploader LIMIT ?"
+	rows, err := r.queryBuilt(query, append(c.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploaders []UploaderCount
+	for rows.Next() {
+		var u UploaderCount
+		if err := rows.Scan(&u.Uploader, &u.Downloads, &u.Bytes); err != nil {
+			return nil, err
+		}
+		uploaders = append(uploaders, u)
+	}
+	return uploaders, rows.Err()
+}
+
+type FailureCount struct {
+	ErrorClass string
+	Count      int
+}
+
+// FailuresByClass counts the failed downloads in the range by error class,
+// most frequent first.
+func (r *Repository) FailuresByClass(filter StatsFilter) ([]FailureCount, error) {
+	c := filter.conditions("f")
+	query := failureClassQuery + " " + c.where() + " GROUP BY COALESCE(f.error_class, 'unknown') ORDER BY COUNT(*) DESC, COALESCE(f.error_class, 'unknown')"
+	rows, err := r.queryBuilt(query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []FailureCount
+	for rows.Next() {
+		var f FailureCount
+		if err := rows.Scan(&f.ErrorClass, &f.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, f)
+	}
+	return counts, rows.Err()
+}
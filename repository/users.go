@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	selectUserProfileQuery = `
+		SELECT u.tier, u.banned_at, COALESCE(u.ban_reason, ''), COALESCE(s.language, ''),
+			(SELECT COUNT(*) FROM failed_downloads f WHERE f.user_id = u.user_id),
+			(SELECT COUNT(*) FROM subscriptions sub WHERE sub.user_id = u.user_id)
+		FROM users u
+		LEFT JOIN user_settings s ON s.user_id = u.user_id
+		WHERE u.user_id = ?`
+	selectUserUsageQuery = `SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM downloads WHERE user_id = ? AND timestamp >= ?`
+	updateUserTierQuery  = `UPDATE users SET tier = ? WHERE user_id = ?`
+	banUserQuery         = `UPDATE users SET banned_at = ?, ban_reason = ? WHERE user_id = ?`
+	unbanUserQuery       = `UPDATE users SET banned_at = NULL, ban_reason = NULL WHERE user_id = ?`
+)
+
+// UserProfile is a user with their downloads and what the admin manages of
+// them. BannedAt is "" unless the bot refuses the user.
+type UserProfile struct {
+	UserStats
+	Tier          string
+	BannedAt      string
+	BanReason     string
+	Language      string
+	Failures      int
+	Subscriptions int
+}
+
+// UserProfile returns the profile of the user with the Telegram ID, or
+// ErrNotFound.
+func (r *Repository) UserProfile(userID int64) (UserProfile, error) {
+	var p UserProfile
+	var err error
+	if p.UserStats, err = r.User(userID); err != nil {
+		return p, err
+	}
+	var bannedAt sql.NullString
+	err = r.scanRow(selectUserProfileQuery, []interface{}{userID}, &p.Tier, &bannedAt, &p.BanReason, &p.Language, &p.Failures, &p.Subscriptions)
+	p.BannedAt = bannedAt.String
+	return p, err
+}
+
+// UserUsage returns the number and bytes of the downloads of the user since
+// the time.
+func (r *Repository) UserUsage(userID int64, since time.Time) (int, int64, error) {
+	var downloads int
+	var bytes int64
+	err := r.scanRow(selectUserUsageQuery, []interface{}{userID, nullTime(since)}, &downloads, &bytes)
+	return downloads, bytes, err
+}
+
+// SetUserTier moves the user to the tier, or returns ErrNotFound.
+func (r *Repository) SetUserTier(userID int64, tier string) error {
+	return r.updateUser(updateUserTierQuery, tier, userID)
+}
+
+// BanUser makes the bot refuse the user, or returns ErrNotFound.
+func (r *Repository) BanUser(userID int64, reason string) error {
+	return r.updateUser(banUserQuery, nullTime(time.Now()), reason, userID)
+}
+
+// UnbanUser lifts the ban of the user, or returns ErrNotFound.
+func (r *Repository) UnbanUser(userID int64) error {
+	return r.updateUser(unbanUserQuery, userID)
+}
+
+func (r *Repository) updateUser(query string, args ...interface{}) error {
+	n, err := r.execCount(query, args...)
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
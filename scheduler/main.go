@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+	"instaVideoDownloaderBot/ytdlp"
+)
+
+type UserInfo struct {
+	UserID    int64  `json:"user_id"`
+	UserName  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type DownloadTask struct {
+	URL       string   `json:"url"`
+	ChatID    int64    `json:"chat_id"`
+	MessageID int      `json:"message_id"`
+	User      UserInfo `json:"user"`
+	Language  string   `json:"language"`
+	Mode      string   `json:"mode"`
+	Platform  string   `json:"platform"`
+}
+
+var databaseFile = "/app/data/videos.db"
+
+var (
+	dueProfilesQuery = `SELECT username, seeded, failures FROM followed_profiles
+		WHERE username IN (SELECT username FROM subscriptions)
+		AND (backoff_until IS NULL OR backoff_until <= ?)
+		ORDER BY last_polled_at IS NOT NULL, last_polled_at`
+	selectSeenPostQuery = `SELECT 1 FROM seen_posts WHERE username = ? AND post_id = ?`
+	insertSeenPostQuery = `INSERT INTO seen_posts (username, post_id) VALUES (?, ?) ON CONFLICT DO NOTHING`
+	subscribersQuery    = `SELECT s.chat_id, s.user_id,
+			COALESCE(MAX(u.username), ''), COALESCE(MAX(u.first_name), ''), COALESCE(MAX(u.last_name), ''),
+			COALESCE(MAX(us.language), ''), COALESCE(MAX(u.tier), '')
+		FROM subscriptions s
+		LEFT JOIN users u ON u.user_id = s.user_id
+		LEFT JOIN user_settings us ON us.user_id = s.user_id
+		WHERE s.username = ? AND u.banned_at IS NULL
+		GROUP BY s.chat_id, s.user_id`
+	profilePolledQuery = `UPDATE followed_profiles SET seeded = 1, failures = 0, backoff_until = NULL, last_error = NULL,
+		last_polled_at = CURRENT_TIMESTAMP WHERE username = ?`
+	profileFailedQuery = `UPDATE followed_profiles SET failures = ?, backoff_until = ?, last_error = ?,
+		last_polled_at = CURRENT_TIMESTAMP WHERE username = ?`
+)
+
+func GetEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
+
+func mustDuration(key, fallback string) time.Duration {
+	d, err := time.ParseDuration(GetEnv(key, fallback))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func initDB() (*repository.Repository, error) {
+	log.Println("Initializing database")
+	repo, err := repository.Open(databaseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The downloader owns the schema and migrates it at startup.
+	err = migrations.Wait(context.Background(), repo.DB())
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+type Post struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type Scheduler struct {
+	repo         *repository.Repository
+	db           *repository.DB
+	tiers        quota.Tiers
+	runner       *ytdlp.Runner
+	instagram    *platform.Platform
+	ch           *amqp091.Channel
+	queue        string
+	postsLimit   int
+	profileDelay time.Duration
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+}
+
+// listPosts returns the latest posts of a public profile, newest first. It
+// uses the cookies accounts and proxies the downloader uses.
+func (s *Scheduler) listPosts(username string) ([]Post, error) {
+	output, _, err := s.runner.Run(s.instagram, s.runner.AcquireProxy(),
+		"--flat-playlist", "-J", "--playlist-end", strconv.Itoa(s.postsLimit),
+		fmt.Sprintf("https://www.instagram.com/%s/", username))
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist struct {
+		Entries []Post `json:"entries"`
+	}
+	err = json.Unmarshal(output, &playlist)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse posts JSON: %w", err)
+	}
+	for i, post := range playlist.Entries {
+		if post.URL == "" {
+			playlist.Entries[i].URL = fmt.Sprintf("https://www.instagram.com/p/%s/", post.ID)
+		}
+	}
+	return playlist.Entries, nil
+}
+
+type profile struct {
+	Username string
+	Seeded   bool
+	Failures int
+}
+
+// poll checks every due profile once.
+func (s *Scheduler) poll() error {
+	rows, err := s.db.Query(dueProfilesQuery, time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return err
+	}
+	var profiles []profile
+	for rows.Next() {
+		var p profile
+		if err := rows.Scan(&p.Username, &p.Seeded, &p.Failures); err != nil {
+			rows.Close()
+			return err
+		}
+		profiles = append(profiles, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, p := range profiles {
+		if i > 0 {
+			time.Sleep(s.profileDelay)
+		}
+		err := s.pollProfile(p)
+		if err == nil {
+			continue
+		}
+
+		backoff := s.backoff(p.Failures + 1)
+		log.Printf("Failed to poll @%s, backing off for %s: %v", p.Username, backoff, err)
+		_, dbErr := s.db.Exec(profileFailedQuery, p.Failures+1, time.Now().Add(backoff).UTC().Format(time.DateTime), err.Error(), p.Username)
+		if dbErr != nil {
+			log.Printf("Failed to save poll failure: %v", dbErr)
+		}
+		// The limit applies to the account or the address, not to one
+		// profile, so the whole round stops.
+		if ytdlp.Class(err) == platform.ErrorRateLimited {
+			log.Println("Rate limited, postponing the remaining profiles until the next round")
+			return nil
+		}
+	}
+	return nil
+}
+
+// backoff doubles the delay with every consecutive failure, up to backoffMax.
+func (s *Scheduler) backoff(failures int) time.Duration {
+	d := s.backoffBase
+	for i := 1; i < failures && d < s.backoffMax; i++ {
+		d *= 2
+	}
+	if d > s.backoffMax {
+		d = s.backoffMax
+	}
+	return d
+}
+
+// pollProfile queues downloads of the posts published since the previous
+// poll. The first poll of a profile only remembers the existing posts, so new
+// followers don't receive the whole backlog.
+func (s *Scheduler) pollProfile(p profile) error {
+	posts, err := s.listPosts(p.Username)
+	if err != nil {
+		return err
+	}
+
+	var fresh []Post
+	for _, post := range posts {
+		var seen int
+		err := s.db.QueryRow(selectSeenPostQuery, p.Username, post.ID).Scan(&seen)
+		if errors.Is(err, sql.ErrNoRows) {
+			fresh = append(fresh, post)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if p.Seeded && len(fresh) > 0 {
+		log.Printf("Found %d new posts of @%s", len(fresh), p.Username)
+	}
+	// Oldest first, so subscribers get posts in publishing order. Each post
+	// is marked seen as soon as it is queued, so a failure halfway doesn't
+	// queue the earlier posts again on the next poll.
+	for i := len(fresh) - 1; i >= 0; i-- {
+		if p.Seeded {
+			if err := s.enqueue(p.Username, fresh[i]); err != nil {
+				return err
+			}
+		}
+		_, err := s.db.Exec(insertSeenPostQuery, p.Username, fresh[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec(profilePolledQuery, p.Username)
+	return err
+}
+
+// enqueue sends a download task of the post to every subscriber who has
+// quota left.
+func (s *Scheduler) enqueue(username string, post Post) error {
+	tasks, err := s.tasks(username, post)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		body, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		err = s.ch.Publish(
+			"",
+			s.queue,
+			false,
+			false,
+			amqp091.Publishing{
+				ContentType: "application/json",
+				Body:        body,
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tasks returns the download tasks of the post for the subscribers of the
+// profile. Banned subscribers and those out of quota are left out.
+func (s *Scheduler) tasks(username string, post Post) ([]DownloadTask, error) {
+	rows, err := s.db.Query(subscribersQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []DownloadTask
+	for rows.Next() {
+		task := DownloadTask{URL: post.URL, Mode: "video", Platform: "instagram"}
+		var tier string
+		err := rows.Scan(&task.ChatID, &task.User.UserID, &task.User.UserName, &task.User.FirstName, &task.User.LastName, &task.Language, &tier)
+		if err != nil {
+			return nil, err
+		}
+		exceeded, err := s.quotaExceeded(task.User.UserID, tier)
+		if err != nil {
+			return nil, err
+		}
+		if exceeded {
+			log.Printf("Skipping post %s of %s for user %d, who is out of quota", post.ID, username, task.User.UserID)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// quotaExceeded reports whether the user downloaded as much as their tier
+// allows in the last quota.Window. Users the bot hasn't recorded yet have no
+// tier and get the limits of the free tier.
+func (s *Scheduler) quotaExceeded(userID int64, tierName string) (bool, error) {
+	tier := s.tiers.Get(tierName)
+	if tier.MaxDownloads == 0 && tier.MaxBytes == 0 {
+		return false, nil
+	}
+	var usage quota.Usage
+	var err error
+	usage.Downloads, usage.Bytes, err = s.repo.UserUsage(userID, time.Now().Add(-quota.Window))
+	if err != nil {
+		return false, err
+	}
+	return tier.Exceeded(usage), nil
+}
+
+func main() {
+	log.Println("Starting scheduler service")
+
+	interval := mustDuration("SCHEDULER_INTERVAL", "15m")
+	postsLimit, err := strconv.Atoi(GetEnv("SCHEDULER_POSTS_PER_PROFILE", "12"))
+	if err != nil || postsLimit <= 0 {
+		log.Fatalf("Invalid SCHEDULER_POSTS_PER_PROFILE: %q", GetEnv("SCHEDULER_POSTS_PER_PROFILE", "12"))
+	}
+
+	conn, err := amqp091.Dial(GetEnv("RABBITMQ_URL", ""))
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclare(
+		"video_download",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatalf("Failed to declare a queue: %v", err)
+	}
+
+	repo, err := initDB()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer repo.Close()
+
+	tiers, err := quota.FromEnv(GetEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The downloader alerts about unhealthy cookies accounts.
+	cookies, err := ytdlp.LoadCookiePools(repo, nil, GetEnv("COOKIES_STRATEGY", ytdlp.StrategyRoundRobin))
+	if err != nil {
+		log.Fatalf("Failed to load cookies: %v", err)
+	}
+	recheckInterval := mustDuration("COOKIES_RECHECK_INTERVAL", "1h")
+	banThreshold, err := strconv.Atoi(GetEnv("PROXY_BAN_THRESHOLD", "3"))
+	if err != nil || banThreshold <= 0 {
+		log.Fatalf("Invalid PROXY_BAN_THRESHOLD: %q", GetEnv("PROXY_BAN_THRESHOLD", "3"))
+	}
+	proxies, err := ytdlp.LoadProxyPool(repo, GetEnv("PROXY_LIST", ""), banThreshold, mustDuration("PROXY_BAN_DURATION", "30m"))
+	if err != nil {
+		log.Fatalf("Failed to load proxies: %v", err)
+	}
+	runner := &ytdlp.Runner{Cookies: cookies, Proxies: proxies}
+	go runner.Revalidate(recheckInterval, GetEnv("COOKIES_PROBE_URL", ""))
+
+	instagram, _ := platform.ByName("instagram")
+	s := &Scheduler{
+		repo:         repo,
+		db:           repo.DB(),
+		tiers:        tiers,
+		runner:       runner,
+		instagram:    instagram,
+		ch:           ch,
+		queue:        q.Name,
+		postsLimit:   postsLimit,
+		profileDelay: mustDuration("SCHEDULER_PROFILE_DELAY", "10s"),
+		backoffBase:  mustDuration("SCHEDULER_BACKOFF_BASE", "30m"),
+		backoffMax:   mustDuration("SCHEDULER_BACKOFF_MAX", "24h"),
+	}
+
+	log.Printf("Polling followed profiles every %s", interval)
+	for {
+		if err := s.poll(); err != nil {
+			log.Printf("Failed to poll followed profiles: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"instaVideoDownloaderBot/migrations"
+	"instaVideoDownloaderBot/quota"
+	"instaVideoDownloaderBot/repository"
+)
+
+func testRepository(t *testing.T) *repository.Repository {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	repo, err := repository.Open(filepath.Join(t.TempDir(), "videos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	if err := migrations.Up(repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// TestTasks checks who gets the posts of a profile: not banned subscribers,
+// nor those who used up their quota.
+func TestTasks(t *testing.T) {
+	repo := testRepository(t)
+	s := &Scheduler{
+		repo:  repo,
+		db:    repo.DB(),
+		tiers: quota.Tiers{{Name: quota.TierFree, MaxDownloads: 2}, {Name: quota.TierPremium}},
+	}
+	download := func(userID int64) {
+		t.Helper()
+		err := repo.RecordDownload(repository.Download{User: repository.User{UserID: userID}, URL: "https://www.instagram.com/p/old/", Platform: "instagram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 has free quota left, 2 is banned, 3 used up the free quota, 4 is
+	// premium and 5 was never recorded.
+	download(1)
+	download(2)
+	if err := repo.BanUser(2, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	download(3)
+	download(3)
+	download(4)
+	download(4)
+	if err := repo.SetUserTier(4, quota.TierPremium); err != nil {
+		t.Fatal(err)
+	}
+	for userID := int64(1); userID <= 5; userID++ {
+		_, err := repo.DB().Exec(`INSERT INTO subscriptions (chat_id, user_id, username) VALUES (?, ?, 'zoo')`, userID*10, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tasks, err := s.tasks("zoo", Post{ID: "new", URL: "https://www.instagram.com/p/new/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chats []int64
+	for _, task := range tasks {
+		chats = append(chats, task.ChatID)
+		if task.URL != "https://www.instagram.com/p/new/" || task.User.UserID*10 != task.ChatID {
+			t.Errorf("got task %+v", task)
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	if len(chats) != 3 || chats[0] != 10 || chats[1] != 40 || chats[2] != 50 {
+		t.Errorf("got tasks for chats %v, want 10, 40 and 50", chats)
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files under Dir. It is only usable when all
+// services see the same directory, i.e. run on one host.
+type Local struct {
+	Dir string
+	// PublicURL is where Dir is served from, e.g. the admin's /static. URLs
+	// are not signed and don't expire.
+	PublicURL string
+}
+
+func NewLocal(dir, publicURL string) *Local {
+	return &Local{Dir: dir, PublicURL: strings.TrimSuffix(publicURL, "/")}
+}
+
+// path maps key to a file under Dir, rejecting keys that escape it.
+func (l *Local) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object next to its final path and renames it, so readers
+// never see a partial file.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List walks Dir. Partial uploads are listed too, so they can be cleaned up
+// when a writer died.
+func (l *Local) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	err := filepath.WalkDir(l.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if l.PublicURL == "" {
+		return "", ErrNoURL
+	}
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	return l.PublicURL + "/" + (&url.URL{Path: key}).EscapedPath(), nil
+}
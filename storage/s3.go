@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	// PublicURL is the service as browsers reach it, e.g.
+	// https://media.example.com, when Endpoint is an internal address such
+	// as minio:9000. Without it SignedURL returns ErrNoURL.
+	PublicURL string
+}
+
+// S3 stores objects in a bucket of an S3-compatible service such as AWS S3
+// or MinIO.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	// public signs URLs for the public address. The signature covers the
+	// host, so URLs signed by client would only work inside the network.
+	public *minio.Client
+}
+
+// NewS3 connects to the service and creates the bucket when it doesn't exist
+// yet.
+func NewS3(config S3Config) (*S3, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("S3_ENDPOINT is not set")
+	}
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", config.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", config.Bucket, err)
+		}
+	}
+	s := &S3{client: client, bucket: config.Bucket}
+	if config.PublicURL != "" {
+		s.public, err = newPublicClient(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// newPublicClient creates a client that only signs URLs for PublicURL. It
+// never sends a request, so it needs the region, which clients otherwise
+// look up from the bucket.
+func newPublicClient(config S3Config) (*minio.Client, error) {
+	u, err := url.Parse(config.PublicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 public URL %q", config.PublicURL)
+	}
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return minio.New(u.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: u.Scheme == "https",
+		Region: region,
+	})
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get checks that the object exists before returning it, since minio-go only
+// reports missing objects on the first read.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		err := fn(ObjectInfo{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignedURL presigns a URL on the public address. Without one the service
+// can't be reached from outside, so there is no URL to hand out.
+func (s *S3) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if s.public == nil {
+		return "", ErrNoURL
+	}
+	u, err := s.public.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,116 @@
+// Package storage keeps the files the services exchange: downloaded media,
+// previews and Telegram thumbnails. Files are addressed by keys, so the bot
+// and the downloader don't need to share a volume when the S3 backend is
+// used.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrNotFound is returned by Get for keys that don't exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrNoURL is returned by SignedURL when the backend can't hand out URLs.
+var ErrNoURL = errors.New("storage: backend has no URLs")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any object
+	// with that key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL the object can be downloaded from until expiry
+	// passes.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// List calls fn for every object whose key starts with prefix.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// FromEnv creates the backend selected by STORAGE_BACKEND: "local" (the
+// default) or "s3".
+func FromEnv() (Storage, error) {
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return NewLocal(getEnv("STORAGE_LOCAL_DIR", "/static"), os.Getenv("STORAGE_PUBLIC_URL")), nil
+	case "s3":
+		useSSL, err := strconv.ParseBool(getEnv("S3_USE_SSL", "true"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_USE_SSL: %w", err)
+		}
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    getEnv("S3_BUCKET", "media"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    useSSL,
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// PutFile stores the file at path under key.
+func PutFile(ctx context.Context, s Storage, key, path, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, file, stat.Size(), contentType)
+}
+
+// GetFile copies the object stored under key into a new file in dir and
+// returns its path. The name keeps the extension of the key.
+func GetFile(ctx context.Context, s Storage, key, dir string) (string, error) {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	file, err := os.CreateTemp(dir, "*"+filepath.Ext(key))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
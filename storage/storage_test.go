@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBackend runs the behavior every backend must share against s, which
+// must be empty.
+func testBackend(t *testing.T, s Storage) {
+	ctx := context.Background()
+	put := func(key, content string) {
+		t.Helper()
+		err := s.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+		if err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	get := func(key string) string {
+		t.Helper()
+		r, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", key, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", key, err)
+		}
+		return string(data)
+	}
+	list := func(prefix string) []string {
+		t.Helper()
+		var keys []string
+		err := s.List(ctx, prefix, func(info ObjectInfo) error {
+			keys = append(keys, info.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("List(%s): %v", prefix, err)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	put("objects/ab/cd/abcd.mp4", "video")
+	put("tmp/thumb.jpg", "thumb")
+	if got := get("objects/ab/cd/abcd.mp4"); got != "video" {
+		t.Errorf("Get returned %q, want %q", got, "video")
+	}
+	put("objects/ab/cd/abcd.mp4", "replaced")
+	if got := get("objects/ab/cd/abcd.mp4"); got != "replaced" {
+		t.Errorf("Get after replacing returned %q, want %q", got, "replaced")
+	}
+
+	if got := strings.Join(list(""), ","); got != "objects/ab/cd/abcd.mp4,tmp/thumb.jpg" {
+		t.Errorf("List of everything returned %s", got)
+	}
+	if got := strings.Join(list("tmp/"), ","); got != "tmp/thumb.jpg" {
+		t.Errorf("List of tmp/ returned %s", got)
+	}
+
+	if _, err := s.Get(ctx, "objects/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key returned %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "tmp/thumb.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "tmp/thumb.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted key returned %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "tmp/thumb.jpg"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+
+	path, err := GetFile(ctx, s, "objects/ab/cd/abcd.mp4", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(path) != ".mp4" {
+		t.Errorf("GetFile returned %s, want an .mp4 file", path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "replaced" {
+		t.Errorf("GetFile wrote %q", data)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	testBackend(t, NewLocal(t.TempDir(), ""))
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(filepath.Join(dir, "static"), "")
+	for _, key := range []string{"../secret", "/etc/passwd", "objects/../../secret", "."} {
+		err := l.Put(context.Background(), key, strings.NewReader("x"), 1, "")
+		if err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secret")); err == nil {
+		t.Error("a key escaped the storage directory")
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewLocal(t.TempDir(), "").SignedURL(ctx, "objects/a.mp4", time.Minute)
+	if !errors.Is(err, ErrNoURL) {
+		t.Errorf("without a public URL: got %v, want ErrNoURL", err)
+	}
+
+	u, err := NewLocal(t.TempDir(), "https://admin.example.com/static/").SignedURL(ctx, "objects/a b.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://admin.example.com/static/objects/a%20b.mp4" {
+		t.Errorf("got %s", u)
+	}
+}
+
+// TestS3SignedURL checks that URLs are signed for the public address, which
+// needs no connection to the service.
+func TestS3SignedURL(t *testing.T) {
+	ctx := context.Background()
+	config := S3Config{Endpoint: "minio:9000", AccessKey: "key", SecretKey: "secret", Bucket: "media"}
+	if _, err := (&S3{bucket: config.Bucket}).SignedURL(ctx, "objects/a.mp4", time.Minute); !errors.Is(err, ErrNoURL) {
+		t.Errorf("without a public URL: got %v, want ErrNoURL", err)
+	}
+
+	config.PublicURL = "https://media.example.com"
+	public, err := newPublicClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := (&S3{bucket: config.Bucket, public: public}).SignedURL(ctx, "objects/a.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "https" || u.Host != "media.example.com" || u.Path != "/media/objects/a.mp4" {
+		t.Errorf("got %s", signed)
+	}
+	if u.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("%s is not signed", signed)
+	}
+
+	for _, invalid := range []string{"media.example.com", "ftp://media.example.com", "https://"} {
+		config.PublicURL = invalid
+		if _, err := newPublicClient(config); err == nil {
+			t.Errorf("public URL %q was accepted", invalid)
+		}
+	}
+}
+
+// TestS3 runs against the service at S3_TEST_ENDPOINT, e.g. a local MinIO
+// started with `docker compose up minio` and S3_TEST_ENDPOINT=localhost:9000.
+// It uses a fresh bucket, which it removes afterwards.
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	config := S3Config{
+		Endpoint:  endpoint,
+		AccessKey: getEnv("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: getEnv("S3_TEST_SECRET_KEY", "minioadmin"),
+		Bucket:    "storage-test-" + time.Now().Format("20060102150405"),
+		UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+		PublicURL: "http://" + endpoint,
+	}
+	if config.UseSSL {
+		config.PublicURL = "https://" + endpoint
+	}
+	s, err := NewS3(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		s.List(ctx, "", func(info ObjectInfo) error {
+			return s.Delete(ctx, info.Key)
+		})
+		if err := s.client.RemoveBucket(ctx, config.Bucket); err != nil {
+			t.Logf("Failed to remove bucket %s: %v", config.Bucket, err)
+		}
+	})
+
+	testBackend(t, s)
+
+	signed, err := s.SignedURL(context.Background(), "objects/ab/cd/abcd.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != "replaced" {
+		t.Errorf("signed URL returned %d %q", resp.StatusCode, data)
+	}
+}
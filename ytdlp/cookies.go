@@ -0,0 +1,289 @@
+package ytdlp
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+)
+
+const (
+	StrategyRoundRobin = "round_robin"
+	StrategyLRU        = "lru"
+)
+
+// Alerter tells the admins about pools that ran out of healthy accounts.
+type Alerter interface {
+	Send(message string)
+}
+
+// CookieAccount is one Netscape cookies file, i.e. one logged in account.
+type CookieAccount struct {
+	Name      string
+	Path      string
+	Healthy   bool
+	LastUsed  time.Time
+	LastError string
+	CheckedAt time.Time
+}
+
+// CookiePool hands out the cookies files of one platform. Accounts that hit a
+// login wall or a checkpoint are taken out of rotation until a revalidation
+// succeeds.
+type CookiePool struct {
+	mu       sync.Mutex
+	platform *platform.Platform
+	strategy string
+	accounts []*CookieAccount
+	next     int
+	allDown  bool
+	repo     *repository.Repository
+	alerts   Alerter
+}
+
+// LoadCookiePools creates a pool per platform from its cookies file and
+// cookies directory (*.txt, *.txt.enc). alerts may be nil when another
+// service alerts about the same accounts.
+func LoadCookiePools(repo *repository.Repository, alerts Alerter, strategy string) (map[string]*CookiePool, error) {
+	if strategy != StrategyRoundRobin && strategy != StrategyLRU {
+		return nil, fmt.Errorf("unknown cookies strategy %q", strategy)
+	}
+
+	pools := map[string]*CookiePool{}
+	for _, p := range platform.All() {
+		var paths []string
+		if file := p.CookiesFile(); file != "" {
+			paths = append(paths, file)
+		}
+		if dir := p.CookiesDir(); dir != "" {
+			for _, pattern := range []string{"*.txt", "*.txt.enc"} {
+				matches, err := filepath.Glob(filepath.Join(dir, pattern))
+				if err != nil {
+					return nil, err
+				}
+				sort.Strings(matches)
+				paths = append(paths, matches...)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		pool := &CookiePool{platform: p, strategy: strategy, repo: repo, alerts: alerts}
+		for _, path := range paths {
+			plain, err := ReadCookies(path)
+			if err != nil {
+				log.Printf("Skipping %s cookies file: %v", p.Name, err)
+				continue
+			}
+			account := &CookieAccount{
+				Name:    strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".enc"), ".txt"),
+				Path:    path,
+				Healthy: true,
+			}
+			log.Printf("Loaded %s cookies account %s: %s", p.Name, account.Name, CookiesSummary(plain))
+			clear(plain)
+			saved, err := repo.CookieAccount(p.Name, account.Name)
+			if err == nil {
+				account.Healthy, account.LastError = saved.Healthy, saved.LastError
+				account.LastUsed, account.CheckedAt = saved.LastUsed, saved.CheckedAt
+			} else if err != repository.ErrNotFound {
+				return nil, err
+			}
+			pool.accounts = append(pool.accounts, account)
+		}
+		if len(pool.accounts) == 0 {
+			continue
+		}
+		log.Printf("Using %d %s cookies accounts", len(pool.accounts), p.Name)
+		pools[p.Name] = pool
+	}
+	return pools, nil
+}
+
+// Acquire returns the next healthy account, or nil when none is left. The
+// health of the accounts is read from the database first, so accounts that
+// another service marked unhealthy or revalidated are taken into account.
+func (pool *CookiePool) Acquire() *CookieAccount {
+	saved, err := pool.repo.CookieAccounts(pool.platform.Name)
+	if err != nil {
+		log.Printf("Failed to load %s cookies accounts: %v", pool.platform.Name, err)
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.sync(saved)
+
+	var chosen *CookieAccount
+	switch pool.strategy {
+	case StrategyLRU:
+		for _, account := range pool.accounts {
+			if account.Healthy && (chosen == nil || account.LastUsed.Before(chosen.LastUsed)) {
+				chosen = account
+			}
+		}
+	default:
+		for i := 0; i < len(pool.accounts); i++ {
+			account := pool.accounts[(pool.next+i)%len(pool.accounts)]
+			if account.Healthy {
+				chosen = account
+				pool.next = (pool.next + i + 1) % len(pool.accounts)
+				break
+			}
+		}
+	}
+	if chosen == nil {
+		log.Printf("No healthy %s cookies accounts left", pool.platform.Name)
+		return nil
+	}
+	chosen.LastUsed = time.Now()
+	return chosen
+}
+
+// Report marks the account unhealthy when the run failed because of a login
+// wall or checkpoint, and alerts the admins when no healthy account is left.
+func (pool *CookiePool) Report(account *CookieAccount, err error) {
+	if err == nil || Class(err) != platform.ErrorLoginRequired {
+		pool.mu.Lock()
+		lastUsed := account.LastUsed
+		pool.mu.Unlock()
+		if err := pool.repo.TouchCookieAccount(pool.platform.Name, account.Name, lastUsed); err != nil {
+			log.Printf("Failed to save cookies account %s: %v", account.Name, err)
+		}
+		return
+	}
+
+	log.Printf("Marking %s cookies account %s as unhealthy: %v", pool.platform.Name, account.Name, err)
+	pool.mu.Lock()
+	account.Healthy = false
+	account.LastError = err.Error()
+	saved := pool.saved(account)
+	pool.checkAllDown()
+	pool.mu.Unlock()
+	pool.save(saved)
+}
+
+// Revalidate probes every unhealthy account with probeURL and puts the ones
+// that work again back into rotation. Without a probe URL accounts simply get
+// another chance.
+func (pool *CookiePool) Revalidate(probeURL string) {
+	pool.mu.Lock()
+	var unhealthy []*CookieAccount
+	for _, account := range pool.accounts {
+		if !account.Healthy {
+			unhealthy = append(unhealthy, account)
+		}
+	}
+	pool.mu.Unlock()
+
+	for _, account := range unhealthy {
+		var err error
+		if probeURL != "" {
+			err = probeCookies(pool.platform, account, probeURL)
+		}
+
+		pool.mu.Lock()
+		account.CheckedAt = time.Now()
+		if err == nil {
+			log.Printf("%s cookies account %s is healthy again", pool.platform.Name, account.Name)
+			account.Healthy = true
+			account.LastError = ""
+		} else {
+			log.Printf("%s cookies account %s is still unhealthy: %v", pool.platform.Name, account.Name, err)
+			account.LastError = err.Error()
+		}
+		saved := pool.saved(account)
+		pool.checkAllDown()
+		pool.mu.Unlock()
+		pool.save(saved)
+	}
+}
+
+// probeCookies checks that account can fetch probeURL.
+func probeCookies(p *platform.Platform, account *CookieAccount, probeURL string) error {
+	path, cleanup, err := MaterializeCookies(account)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cmd := exec.Command("yt-dlp", "--simulate", "--quiet", "--cookies", path, probeURL)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return NewDownloadError(p, err, output)
+	}
+	return nil
+}
+
+// saved returns the state of account to store. The caller holds pool.mu; the
+// state is stored with save once it is released.
+func (pool *CookiePool) saved(account *CookieAccount) repository.CookieAccount {
+	return repository.CookieAccount{
+		Platform:  pool.platform.Name,
+		Name:      account.Name,
+		Healthy:   account.Healthy,
+		LastUsed:  account.LastUsed,
+		LastError: account.LastError,
+		CheckedAt: account.CheckedAt,
+	}
+}
+
+func (pool *CookiePool) save(account repository.CookieAccount) {
+	if err := pool.repo.SaveCookieAccount(account); err != nil {
+		log.Printf("Failed to save cookies account %s: %v", account.Name, err)
+	}
+}
+
+// sync takes the health of the accounts from their saved state, which the
+// services sharing the database keep up to date. The caller holds pool.mu.
+func (pool *CookiePool) sync(saved []repository.CookieAccount) {
+	if len(saved) == 0 {
+		return
+	}
+	byName := map[string]repository.CookieAccount{}
+	for _, account := range saved {
+		byName[account.Name] = account
+	}
+	for _, account := range pool.accounts {
+		state, ok := byName[account.Name]
+		if !ok {
+			continue
+		}
+		account.Healthy, account.LastError, account.CheckedAt = state.Healthy, state.LastError, state.CheckedAt
+		if state.LastUsed.After(account.LastUsed) {
+			account.LastUsed = state.LastUsed
+		}
+	}
+	pool.checkAllDown()
+}
+
+// checkAllDown alerts once when the last healthy account goes down and once
+// when an account recovers. The caller holds pool.mu.
+func (pool *CookiePool) checkAllDown() {
+	allDown := true
+	for _, account := range pool.accounts {
+		if account.Healthy {
+			allDown = false
+			break
+		}
+	}
+	if allDown == pool.allDown {
+		return
+	}
+	pool.allDown = allDown
+	if pool.alerts == nil {
+		return
+	}
+	if allDown {
+		pool.alerts.Send(fmt.Sprintf("All %d %s cookies accounts are unhealthy. Downloads continue without cookies until one is revalidated.", len(pool.accounts), pool.platform.Title))
+	} else {
+		pool.alerts.Send(fmt.Sprintf("A %s cookies account is healthy again.", pool.platform.Title))
+	}
+}
@@ -0,0 +1,171 @@
+package ytdlp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"instaVideoDownloaderBot/platform"
+	"instaVideoDownloaderBot/repository"
+)
+
+// Proxy is one outbound HTTP or SOCKS5 proxy. Name is the URL without the
+// password; it is what gets logged and stored.
+type Proxy struct {
+	URL                 *url.URL
+	Name                string
+	Successes           int
+	Failures            int
+	ConsecutiveFailures int
+	BannedUntil         time.Time
+	LastError           string
+}
+
+// ProxyPool hands out proxies round-robin and bans a proxy for banDuration
+// after banThreshold consecutive failures. The counters and bans live in
+// proxy_stats, shared by the services using the proxies.
+type ProxyPool struct {
+	mu           sync.Mutex
+	proxies      []*Proxy
+	next         int
+	banThreshold int
+	banDuration  time.Duration
+	repo         *repository.Repository
+}
+
+// LoadProxyPool parses list, a comma or whitespace separated list of proxy
+// URLs (http://, https://, socks5://). Counters are restored from proxy_stats.
+func LoadProxyPool(repo *repository.Repository, list string, banThreshold int, banDuration time.Duration) (*ProxyPool, error) {
+	pool := &ProxyPool{banThreshold: banThreshold, banDuration: banDuration, repo: repo}
+	for _, raw := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+
+		proxy := &Proxy{URL: u, Name: u.Redacted()}
+		stats, err := repo.ProxyStats(proxy.Name)
+		if err == nil {
+			proxy.update(stats)
+		} else if err != repository.ErrNotFound {
+			return nil, err
+		}
+		pool.proxies = append(pool.proxies, proxy)
+	}
+	if len(pool.proxies) > 0 {
+		log.Printf("Using %d proxies", len(pool.proxies))
+	}
+	return pool, nil
+}
+
+// Acquire returns the next proxy that isn't banned, or nil when no proxy is
+// configured or all are banned. Without a proxy requests go out directly, or
+// through HTTP_PROXY when it is set. Bans are read from the database first,
+// so a proxy another service banned is skipped too.
+func (pool *ProxyPool) Acquire() *Proxy {
+	if len(pool.proxies) == 0 {
+		return nil
+	}
+	saved, err := pool.repo.ListProxyStats()
+	if err != nil {
+		log.Printf("Failed to load proxy stats: %v", err)
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, stats := range saved {
+		if proxy := pool.find(stats.Proxy); proxy != nil {
+			proxy.update(stats)
+		}
+	}
+
+	now := time.Now()
+	for i := 0; i < len(pool.proxies); i++ {
+		proxy := pool.proxies[(pool.next+i)%len(pool.proxies)]
+		if proxy.BannedUntil.After(now) {
+			continue
+		}
+		pool.next = (pool.next + i + 1) % len(pool.proxies)
+		return proxy
+	}
+	log.Println("All proxies are banned")
+	return nil
+}
+
+// Report counts the outcome of a request made through proxy. Errors about the
+// content itself (private, not found, unsupported) or the cookies account say
+// nothing about the proxy and count as successes.
+func (pool *ProxyPool) Report(proxy *Proxy, err error) {
+	if proxy == nil {
+		return
+	}
+
+	var lastError string
+	if err != nil && proxyFault(err) {
+		lastError = err.Error()
+	}
+	stats, dbErr := pool.repo.RecordProxyResult(proxy.Name, lastError)
+	if dbErr == nil && stats.ConsecutiveFailures >= pool.banThreshold {
+		log.Printf("Banning proxy %s for %s after %d failures: %v", proxy.Name, pool.banDuration, stats.ConsecutiveFailures, err)
+		stats, dbErr = pool.repo.BanProxy(proxy.Name, time.Now().Add(pool.banDuration))
+	}
+	if dbErr != nil {
+		log.Printf("Failed to save proxy stats: %v", dbErr)
+		return
+	}
+
+	pool.mu.Lock()
+	proxy.update(stats)
+	pool.mu.Unlock()
+}
+
+// find returns the proxy named name, or nil. The caller holds pool.mu.
+func (pool *ProxyPool) find(name string) *Proxy {
+	for _, proxy := range pool.proxies {
+		if proxy.Name == name {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// update copies the saved counters of the proxy. The caller holds pool.mu.
+func (proxy *Proxy) update(stats repository.ProxyStats) {
+	proxy.Successes, proxy.Failures, proxy.ConsecutiveFailures = stats.Successes, stats.Failures, stats.ConsecutiveFailures
+	proxy.BannedUntil, proxy.LastError = stats.BannedUntil, stats.LastError
+}
+
+func proxyFault(err error) bool {
+	switch Class(err) {
+	case platform.ErrorPrivate, platform.ErrorNotFound, platform.ErrorUnsupported, platform.ErrorLoginRequired:
+		return false
+	}
+	return true
+}
+
+// ProxyArgs returns the yt-dlp arguments that route a run through proxy.
+func ProxyArgs(proxy *Proxy) []string {
+	if proxy == nil {
+		return nil
+	}
+	return []string{"--proxy", proxy.URL.String()}
+}
+
+// HTTPClient returns a client with the given timeout that goes through proxy.
+func HTTPClient(proxy *Proxy, timeout time.Duration) *http.Client {
+	if proxy == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy.URL)
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
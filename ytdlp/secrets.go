@@ -0,0 +1,206 @@
+package ytdlp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encryptedMagic starts every cookies file encrypted by EncryptCookies. It is
+// followed by the GCM nonce and the sealed Netscape cookies file.
+var encryptedMagic = []byte("IVDB-COOKIES-AES256GCM\n")
+
+// secretsDir is where decrypted cookies live while yt-dlp runs, set by
+// SECRETS_TMP_DIR. It should be a tmpfs, so the plaintext never reaches a
+// disk.
+func secretsDir() string {
+	if dir, ok := os.LookupEnv("SECRETS_TMP_DIR"); ok {
+		return dir
+	}
+	return "/dev/shm"
+}
+
+// cookiesKey reads the AES-256 key from COOKIES_KEY (base64 of 32 bytes).
+func cookiesKey() ([]byte, error) {
+	encoded := os.Getenv("COOKIES_KEY")
+	if encoded == "" {
+		return nil, errors.New("COOKIES_KEY environment variable is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid COOKIES_KEY: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid COOKIES_KEY: want 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func cookiesCipher() (cipher.AEAD, error) {
+	key, err := cookiesKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether data is a cookies file encrypted by
+// EncryptCookies.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// EncryptCookies seals a Netscape cookies file with COOKIES_KEY.
+func EncryptCookies(plain []byte) ([]byte, error) {
+	gcm, err := cookiesCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+func decryptCookies(data []byte) ([]byte, error) {
+	gcm, err := cookiesCipher()
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, encryptedMagic)
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted cookies file is truncated")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt cookies: %w", err)
+	}
+	return plain, nil
+}
+
+// ReadCookies returns the plaintext of a cookies file, decrypting it when it
+// was encrypted with EncryptCookies.
+func ReadCookies(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(data) {
+		return decryptCookies(data)
+	}
+	return data, nil
+}
+
+// CookiesSummary describes a cookies file without revealing it: a short
+// fingerprint of the content and when its cookies expire.
+func CookiesSummary(plain []byte) string {
+	sum := sha256.Sum256(plain)
+	fingerprint := hex.EncodeToString(sum[:6])
+
+	now := time.Now()
+	var total, expired, session int
+	var earliest time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(plain))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// #HttpOnly_ prefixes are cookies too, other # lines are comments.
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		total++
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil || expires == 0 {
+			session++
+			continue
+		}
+		at := time.Unix(expires, 0)
+		if at.Before(now) {
+			expired++
+			continue
+		}
+		if earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+
+	summary := fmt.Sprintf("fingerprint %s, %d cookies, %d expired, %d session", fingerprint, total, expired, session)
+	if !earliest.IsZero() {
+		summary += fmt.Sprintf(", next expiry %s", earliest.UTC().Format(time.DateOnly))
+	}
+	return summary
+}
+
+// MaterializeCookies returns a path yt-dlp can read the cookies of account
+// from. Plain files are used in place. Encrypted files are decrypted to a
+// private file in secretsDir, which the returned cleanup overwrites and
+// removes; cookies yt-dlp updates during the run are discarded with it.
+func MaterializeCookies(account *CookieAccount) (string, func(), error) {
+	data, err := os.ReadFile(account.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !IsEncrypted(data) {
+		return account.Path, func() {}, nil
+	}
+
+	plain, err := decryptCookies(data)
+	if err != nil {
+		return "", nil, err
+	}
+	file, err := os.CreateTemp(secretsDir(), "cookies-*.txt")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		wipeFile(file.Name())
+	}
+	_, err = file.Write(plain)
+	clear(plain)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
+
+// wipeFile overwrites a file with zeros before removing it.
+func wipeFile(path string) {
+	stat, err := os.Stat(path)
+	if err == nil {
+		err = os.WriteFile(path, make([]byte, stat.Size()), 0600)
+	}
+	if err != nil {
+		log.Printf("Failed to wipe %s: %v", path, err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %v", path, err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package ytdlp runs yt-dlp for the services that fetch from the platforms:
+// the downloader and the scheduler. Runs go through a proxy of a ProxyPool
+// and the next account of the platform's CookiePool, whose cookies files may
+// be encrypted, and failures come back classified as a *DownloadError.
+package ytdlp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"instaVideoDownloaderBot/platform"
+)
+
+// DownloadError is a failed yt-dlp run together with its classified reason.
+type DownloadError struct {
+	Class   platform.ErrorClass
+	Message string
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Class, e.Message)
+}
+
+// NewDownloadError classifies the output of a failed yt-dlp run. The message
+// is the last ERROR line, which is what yt-dlp reports the failure with.
+func NewDownloadError(p *platform.Platform, err error, output []byte) *DownloadError {
+	message := err.Error()
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "ERROR:") {
+			message = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+		}
+	}
+	return &DownloadError{Class: p.ClassifyError(string(output)), Message: message}
+}
+
+// Class returns the class of a download error, or unknown for errors that
+// didn't come from yt-dlp.
+func Class(err error) platform.ErrorClass {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr.Class
+	}
+	return platform.ErrorUnknown
+}
+
+// Runner runs yt-dlp with the cookies and proxies of a service.
+type Runner struct {
+	// Cookies holds a pool per platform that has cookies configured.
+	Cookies map[string]*CookiePool
+	Proxies *ProxyPool
+}
+
+// Run runs yt-dlp with args for the platform through proxy, using the next
+// account of its cookie pool when it has one, and reports the outcome back to
+// both pools. Failures are returned as a *DownloadError together with
+// yt-dlp's stderr.
+func (r *Runner) Run(p *platform.Platform, proxy *Proxy, args ...string) ([]byte, []byte, error) {
+	args = append(ProxyArgs(proxy), args...)
+	pool := r.Cookies[p.Name]
+	var account *CookieAccount
+	if pool != nil {
+		account = pool.Acquire()
+	}
+	if account != nil {
+		path, cleanup, err := MaterializeCookies(account)
+		if err != nil {
+			log.Printf("Failed to read %s cookies account %s, running without cookies: %v", p.Name, account.Name, err)
+			account = nil
+		} else {
+			defer cleanup()
+			args = append([]string{"--cookies", path}, args...)
+		}
+	}
+
+	cmd := exec.Command("yt-dlp", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		err = NewDownloadError(p, err, stderr.Bytes())
+	}
+	if account != nil {
+		pool.Report(account, err)
+	}
+	if r.Proxies != nil {
+		r.Proxies.Report(proxy, err)
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// AcquireProxy returns the proxy for the next task, nil when the runner has
+// no proxies or all of them are banned.
+func (r *Runner) AcquireProxy() *Proxy {
+	if r.Proxies == nil {
+		return nil
+	}
+	return r.Proxies.Acquire()
+}
+
+// Revalidate periodically probes the unhealthy accounts of all cookie pools.
+func (r *Runner) Revalidate(interval time.Duration, probeURL string) {
+	for range time.Tick(interval) {
+		for _, pool := range r.Cookies {
+			pool.Revalidate(probeURL)
+		}
+	}
+}